@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// databases are the databases of a data directory cosmprund knows how to compact.
+var databases = []string{"application", "blockstore", "state", "tx_index", "evmindexer"}
+
+// compactCmd compacts databases in key range chunks without pruning them.
+func compactCmd() *cobra.Command {
+	var (
+		dbNames []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compact [path_to_home]",
+		Short: "compact databases in key range chunks, reporting the space reclaimed by each chunk",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner(args[0])
+			if err != nil {
+				return err
+			}
+			_, err = p.Compact(cmd.Context(), dbNames)
+			return err
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&dbNames, "db", databases, "databases to compact (application|blockstore|state|tx_index|evmindexer)")
+
+	return cmd
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	db "github.com/cometbft/cometbft-db"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// compareCmd locates the stores and keys where the state of two nodes diverges.
+func compareCmd() *cobra.Command {
+	var (
+		height  int64
+		maxKeys int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compare [path_to_home_a] [path_to_home_b]",
+		Short: "compare the application state of two nodes at a height and print the first diverging keys",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if height <= 0 {
+				return fmt.Errorf("--height must be set")
+			}
+
+			dbA, err := openAppDB(args[0], true)
+			if err != nil {
+				return err
+			}
+			defer dbA.Close()
+			dbB, err := openAppDB(args[1], true)
+			if err != nil {
+				return err
+			}
+			defer dbB.Close()
+
+			infoA, err := rootmulti.NewStore(dbA, logger).GetCommitInfo(height)
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+			infoB, err := rootmulti.NewStore(dbB, logger).GetCommitInfo(height)
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[1], err)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "app hash A: %X\n", infoA.Hash())
+			fmt.Fprintf(out, "app hash B: %X\n", infoB.Hash())
+			if bytes.Equal(infoA.Hash(), infoB.Hash()) {
+				fmt.Fprintln(out, "app hashes match")
+				return nil
+			}
+
+			hashesA := storeHashes(infoA)
+			hashesB := storeHashes(infoB)
+			names := make([]string, 0, len(hashesA))
+			for name := range hashesA {
+				names = append(names, name)
+			}
+			for name := range hashesB {
+				if _, ok := hashesA[name]; !ok {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+
+			var diverged []string
+			for _, name := range names {
+				hashA, okA := hashesA[name]
+				hashB, okB := hashesB[name]
+				switch {
+				case !okA:
+					fmt.Fprintf(out, "store %s only exists in B\n", name)
+				case !okB:
+					fmt.Fprintf(out, "store %s only exists in A\n", name)
+				case !bytes.Equal(hashA, hashB):
+					fmt.Fprintf(out, "store %s differs: %X != %X\n", name, hashA, hashB)
+					diverged = append(diverged, name)
+				}
+			}
+
+			for _, name := range diverged {
+				kvA, err := loadStoreAtHeight(dbA, name, height)
+				if err != nil {
+					return fmt.Errorf("%s: %w", args[0], err)
+				}
+				kvB, err := loadStoreAtHeight(dbB, name, height)
+				if err != nil {
+					return fmt.Errorf("%s: %w", args[1], err)
+				}
+
+				fmt.Fprintf(out, "\nfirst diverging keys of store %s (- only in A, + only in B):\n", name)
+				n := 0
+				err = diffKVStores(kvA, kvB, func(d keyDiff) error {
+					printKeyDiff(out, name, d)
+					n++
+					if maxKeys > 0 && n >= maxKeys {
+						return errStopDiff
+					}
+					return nil
+				})
+				if err != nil {
+					return fmt.Errorf("failed to diff store %s: %w", name, err)
+				}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Int64Var(&height, "height", 0, "height to compare, must be retained on both nodes")
+	cmd.Flags().IntVar(&maxKeys, "keys", 10, "number of diverging keys to print per store (0 for all)")
+
+	return cmd
+}
+
+func storeHashes(cInfo *storetypes.CommitInfo) map[string][]byte {
+	hashes := make(map[string][]byte, len(cInfo.StoreInfos))
+	for _, info := range cInfo.StoreInfos {
+		hashes[info.Name] = info.CommitId.Hash
+	}
+	return hashes
+}
+
+// loadStoreAtHeight returns a read only view of a single store at height.
+func loadStoreAtHeight(appDB db.DB, name string, height int64) (storetypes.KVStore, error) {
+	appStore, err := loadAppStoreAt(appDB, 0, name)
+	if err != nil {
+		return nil, err
+	}
+	return kvStoreAt(appStore, name, height)
+}
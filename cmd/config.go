@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// initConfig reads the --config file, if one is given, and refreshes the flag
+// variables from viper so config entries apply unless a flag is set explicitly.
+func initConfig() error {
+	if cfgFile == "" {
+		return nil
+	}
+
+	viper.SetConfigFile(cfgFile)
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read config %s: %w", cfgFile, err)
+	}
+
+	blocks = viper.GetUint64("blocks")
+	versions = viper.GetUint64("versions")
+	backend = viper.GetString("backend")
+	app = viper.GetString("app")
+	cosmosSdk = viper.GetBool("cosmos-sdk")
+	tendermint = viper.GetBool("tendermint")
+	debug = viper.GetBool("debug")
+	disableFastNode = viper.GetBool("disable-fast-node")
+	concurrency = viper.GetInt("concurrency")
+	ioRateLimit = viper.GetString("io-rate-limit")
+	iavlCacheSize = viper.GetInt("iavl-cache-size")
+	fastNodeWhitelist = viper.GetStringSlice("fast-node-whitelist")
+	memoryBudget = viper.GetString("memory-budget")
+	dbPreset = viper.GetString("db-preset")
+	minFreeSpace = viper.GetString("min-free-space")
+	pruneStrategy = viper.GetString("prune-strategy")
+	copyThreshold = viper.GetFloat64("copy-threshold")
+	migrateLegacy = viper.GetBool("migrate-legacy")
+	pruneWasm = viper.GetBool("wasm")
+	wasmDir = viper.GetString("wasm-dir")
+	storeDBs = viper.GetStringMapString("store-db")
+	memIAVLKeepRecent = viper.GetUint64("memiavl-keep-recent")
+
+	return nil
+}
@@ -0,0 +1,483 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cometbft/cometbft/libs/log"
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/diskusage"
+	"github.com/binaryholdings/cosmos-pruner/internal/schedule"
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+// Daemon states, as reported in the status.
+const (
+	daemonIdle      = "idle"
+	daemonStopping  = "stopping node"
+	daemonPruning   = "pruning"
+	daemonVerifying = "verifying"
+	daemonStarting  = "starting node"
+	// daemonHalted is the state after a window left the node stopped. The
+	// daemon runs no more windows until it is restarted.
+	daemonHalted = "halted, node left stopped"
+)
+
+// errNodeLeftStopped marks a window that left the node stopped: the prune
+// failed after changing the databases, or they did not verify.
+var errNodeLeftStopped = errors.New("node left stopped")
+
+// permanentError is an error retry does not retry.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// daemonCmd prunes a node in windows set by a cron schedule or the size of
+// its data directory, stopping the node for the run and starting it after.
+func daemonCmd() *cobra.Command {
+	var (
+		cronExpr      string
+		sizeThreshold string
+		checkInterval time.Duration
+		minInterval   time.Duration
+		systemdUnit   string
+		stopCmd       string
+		startCmd      string
+		retries       int
+		retryBackoff  time.Duration
+		statusFile    string
+		statusAddr    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "daemon [path_to_home]",
+		Short: "stop the node, prune, verify and start the node again on a schedule or once the data directory outgrows a size",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			d := &daemon{
+				home:         args[0],
+				dataDir:      rootify(dataDir, args[0]),
+				newPruner:    newPruner,
+				retries:      retries,
+				retryBackoff: retryBackoff,
+				statusFile:   statusFile,
+				logger:       logger.With("module", "daemon"),
+			}
+
+			if cronExpr != "" {
+				c, err := schedule.ParseCron(cronExpr)
+				if err != nil {
+					return err
+				}
+				d.cron = c
+			}
+			if sizeThreshold != "" {
+				threshold, err := throttle.ParseBytes(sizeThreshold)
+				if err != nil {
+					return err
+				}
+				if checkInterval <= 0 {
+					return fmt.Errorf("--check-interval must be positive")
+				}
+				d.sizeThreshold, d.checkInterval, d.minInterval = threshold, checkInterval, minInterval
+			}
+			if d.cron == nil && d.sizeThreshold == 0 {
+				return fmt.Errorf("set --schedule, --size-threshold or both")
+			}
+
+			if systemdUnit != "" {
+				if stopCmd != "" || startCmd != "" {
+					return fmt.Errorf("--systemd-unit cannot be used with --stop-cmd and --start-cmd")
+				}
+				stopCmd, startCmd = "systemctl stop "+systemdUnit, "systemctl start "+systemdUnit
+			}
+			if stopCmd == "" || startCmd == "" {
+				// pruning the databases of a running node corrupts them
+				return fmt.Errorf("set --systemd-unit, or both --stop-cmd and --start-cmd")
+			}
+			d.stopCmd, d.startCmd = stopCmd, startCmd
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if statusAddr != "" {
+				srv, err := d.serveStatus(statusAddr)
+				if err != nil {
+					return err
+				}
+				defer srv.Close()
+			}
+			return d.run(ctx)
+		},
+	}
+
+	cmd.Flags().StringVar(&cronExpr, "schedule", "", "cron expression of the pruning windows, in local time, e.g. \"30 3 * * 0\" or @daily")
+	cmd.Flags().StringVar(&sizeThreshold, "size-threshold", "", "also prune once the data directory is larger than this, e.g. 500GiB")
+	cmd.Flags().DurationVar(&checkInterval, "check-interval", 10*time.Minute, "how often to measure the data directory for --size-threshold")
+	cmd.Flags().DurationVar(&minInterval, "min-interval", 6*time.Hour, "least time between two runs triggered by --size-threshold")
+	cmd.Flags().StringVar(&systemdUnit, "systemd-unit", "", "systemd unit of the node, stopped and started with systemctl")
+	cmd.Flags().StringVar(&stopCmd, "stop-cmd", "", "shell command that stops the node and returns once it has exited")
+	cmd.Flags().StringVar(&startCmd, "start-cmd", "", "shell command that starts the node")
+	cmd.Flags().IntVar(&retries, "retries", 3, "times to retry a failed prune, node stop or node start")
+	cmd.Flags().DurationVar(&retryBackoff, "retry-backoff", time.Minute, "wait before the first retry, doubled for every further one")
+	cmd.Flags().StringVar(&statusFile, "status-file", "", "write the status and the last run to this file as JSON")
+	cmd.Flags().StringVar(&statusAddr, "status-addr", "", "serve the status and the last run as JSON over HTTP on this address, e.g. localhost:26680")
+
+	return cmd
+}
+
+// daemonStatus is what the daemon is doing and how its last run went.
+type daemonStatus struct {
+	State   string     `json:"state"`
+	NextRun *time.Time `json:"next_run,omitempty"`
+	LastRun *daemonRun `json:"last_run,omitempty"`
+}
+
+// daemonRun is one pruning window.
+type daemonRun struct {
+	Trigger  string               `json:"trigger"`
+	Started  time.Time            `json:"started"`
+	Finished *time.Time           `json:"finished,omitempty"`
+	Attempts int                  `json:"attempts"`
+	Status   pruner.PhaseStatus   `json:"status"`
+	Error    string               `json:"error,omitempty"`
+	Phases   []*pruner.Phase      `json:"phases,omitempty"`
+	Verify   *pruner.VerifyResult `json:"verify,omitempty"`
+}
+
+type daemon struct {
+	home      string
+	dataDir   string
+	newPruner func(home string) (*pruner.Pruner, error)
+
+	cron          *schedule.Cron
+	sizeThreshold int64
+	checkInterval time.Duration
+	minInterval   time.Duration
+
+	stopCmd  string
+	startCmd string
+
+	retries      int
+	retryBackoff time.Duration
+	statusFile   string
+	logger       log.Logger
+
+	mu      sync.Mutex
+	status  daemonStatus
+	lastRun time.Time
+	// sizeBackoff, when set, replaces minInterval after size triggered runs
+	// that left the data directory over the threshold.
+	sizeBackoff time.Duration
+}
+
+// run waits for the pruning windows and runs them until ctx is done.
+func (d *daemon) run(ctx context.Context) error {
+	d.setState(daemonIdle)
+	for {
+		trigger, err := d.wait(ctx)
+		if ctx.Err() != nil {
+			d.logger.Info("daemon stopped")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = d.window(ctx, trigger)
+		if errors.Is(err, errNodeLeftStopped) {
+			d.setState(daemonHalted)
+			d.logger.Error("pruning window left the node stopped, check the data directory and start the node by hand", "trigger", trigger, "err", err)
+			<-ctx.Done()
+			d.logger.Info("daemon stopped")
+			return nil
+		}
+		if err != nil {
+			d.logger.Error("pruning window failed", "trigger", trigger, "err", err)
+		}
+		if trigger == "size" {
+			d.checkSizeAfterRun()
+		}
+	}
+}
+
+// wait returns what triggered the next pruning window once it is due.
+func (d *daemon) wait(ctx context.Context) (string, error) {
+	var cronC <-chan time.Time
+	if d.cron != nil {
+		next := d.cron.Next(time.Now())
+		if next.IsZero() {
+			return "", fmt.Errorf("cron schedule never matches")
+		}
+		d.mu.Lock()
+		d.status.NextRun = &next
+		d.mu.Unlock()
+		d.writeStatus()
+		d.logger.Info("next pruning window", "at", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+		cronC = timer.C
+	}
+
+	var checkC <-chan time.Time
+	if d.sizeThreshold > 0 {
+		ticker := time.NewTicker(d.checkInterval)
+		defer ticker.Stop()
+		checkC = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-cronC:
+			return "schedule", nil
+		case <-checkC:
+			interval := d.minInterval
+			if d.sizeBackoff > 0 {
+				interval = d.sizeBackoff
+			}
+			if !d.lastRun.IsZero() && time.Since(d.lastRun) < interval {
+				continue
+			}
+			size, err := diskusage.DirSize(d.dataDir)
+			if err != nil {
+				d.logger.Error("failed to measure the data directory", "dir", d.dataDir, "err", err)
+				continue
+			}
+			if size >= d.sizeThreshold {
+				d.logger.Info("data directory is over the size threshold", "size", size, "threshold", d.sizeThreshold)
+				return "size", nil
+			}
+			d.sizeBackoff = 0
+		}
+	}
+}
+
+// window stops the node, prunes and verifies the data directory and starts
+// the node again. A prune that fails before changing the databases is retried
+// while the node is stopped, and the node is started on the databases as they
+// were once the retries run out. A prune that fails after changing them, or
+// databases that do not verify, leave the node stopped.
+func (d *daemon) window(ctx context.Context, trigger string) (err error) {
+	run := &daemonRun{Trigger: trigger, Started: time.Now(), Status: pruner.PhaseRunning}
+	d.mu.Lock()
+	d.status.LastRun, d.status.NextRun = run, nil
+	d.lastRun = run.Started
+	d.mu.Unlock()
+	d.logger.Info("pruning window started", "trigger", trigger)
+
+	defer func() {
+		finished := time.Now()
+		d.mu.Lock()
+		run.Finished = &finished
+		run.Status = pruner.PhaseDone
+		if err != nil {
+			run.Status, run.Error = pruner.PhaseFailed, err.Error()
+		}
+		d.mu.Unlock()
+		d.setState(daemonIdle)
+		if err == nil {
+			d.logger.Info("pruning window complete", "took", finished.Sub(run.Started).String())
+		}
+	}()
+
+	d.setState(daemonStopping)
+	if err := d.retry(ctx, "stop node", func(ctx context.Context) error {
+		return d.nodeCommand(ctx, d.stopCmd)
+	}); err != nil {
+		// the node may be half way down, try to bring it back
+		return errors.Join(err, d.startNode(ctx))
+	}
+
+	var p *pruner.Pruner
+	pruneErr := d.retry(ctx, "prune", func(ctx context.Context) error {
+		d.mu.Lock()
+		run.Attempts++
+		d.mu.Unlock()
+		var err error
+		p, err = d.prune(ctx, run)
+		return err
+	})
+	if pruneErr != nil {
+		var permanent permanentError
+		if errors.As(pruneErr, &permanent) {
+			return fmt.Errorf("%w: %w", errNodeLeftStopped, pruneErr)
+		}
+		return errors.Join(pruneErr, d.startNode(ctx))
+	}
+
+	if err := d.verify(ctx, p, run); err != nil {
+		return fmt.Errorf("%w: %w", errNodeLeftStopped, err)
+	}
+	return d.startNode(ctx)
+}
+
+// prune runs the prune pipeline. Its error is permanent once the run changed
+// the databases.
+func (d *daemon) prune(ctx context.Context, run *daemonRun) (*pruner.Pruner, error) {
+	p, err := d.newPruner(d.home)
+	if err != nil {
+		return nil, err
+	}
+
+	d.setState(daemonPruning)
+	report, err := p.Run(ctx)
+	d.mu.Lock()
+	run.Phases = report.Phases
+	d.mu.Unlock()
+	if err != nil && report.Modified() {
+		return nil, permanentError{err}
+	}
+	return p, err
+}
+
+// verify checks the pruned data directory once: databases that do not verify
+// will not on a retry either.
+func (d *daemon) verify(ctx context.Context, p *pruner.Pruner, run *daemonRun) error {
+	d.setState(daemonVerifying)
+	result, err := p.Verify(ctx)
+	d.mu.Lock()
+	run.Verify = result
+	d.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	return nil
+}
+
+// checkSizeAfterRun backs off the size trigger when the run it triggered left
+// the data directory over the threshold, which pruning more often will not
+// fix: the least time between size triggered runs doubles until a check finds
+// the data directory under the threshold.
+func (d *daemon) checkSizeAfterRun() {
+	size, err := diskusage.DirSize(d.dataDir)
+	if err != nil {
+		d.logger.Error("failed to measure the data directory", "dir", d.dataDir, "err", err)
+		return
+	}
+	if size < d.sizeThreshold {
+		d.sizeBackoff = 0
+		return
+	}
+	if d.sizeBackoff = 2 * d.sizeBackoff; d.sizeBackoff == 0 {
+		d.sizeBackoff = 2 * max(d.minInterval, d.checkInterval)
+	}
+	d.logger.Info("pruning left the data directory over the size threshold, backing off",
+		"size", size, "threshold", d.sizeThreshold, "next_run_after", d.sizeBackoff.String())
+}
+
+// startNode starts the node, even once ctx is done: a daemon told to exit
+// mid run still leaves a node with unchanged databases running.
+func (d *daemon) startNode(ctx context.Context) error {
+	d.setState(daemonStarting)
+	return d.retry(context.WithoutCancel(ctx), "start node", func(ctx context.Context) error {
+		return d.nodeCommand(ctx, d.startCmd)
+	})
+}
+
+// retry runs fn up to d.retries more times while it fails with an error that
+// is not permanent, doubling the wait between attempts from d.retryBackoff.
+func (d *daemon) retry(ctx context.Context, what string, fn func(ctx context.Context) error) error {
+	backoff := d.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		var permanent permanentError
+		if err == nil || attempt >= d.retries || ctx.Err() != nil || errors.As(err, &permanent) {
+			if err != nil {
+				return fmt.Errorf("%s: %w", what, err)
+			}
+			return nil
+		}
+		d.logger.Error("retrying", "what", what, "attempt", attempt+1, "in", backoff.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", what, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// nodeCommand runs a node stop or start command with sh.
+func (d *daemon) nodeCommand(ctx context.Context, command string) error {
+	d.logger.Info("running node command", "cmd", command)
+	out, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%q: %w: %s", command, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (d *daemon) setState(state string) {
+	d.mu.Lock()
+	d.status.State = state
+	d.mu.Unlock()
+	d.writeStatus()
+}
+
+func (d *daemon) statusJSON() ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return json.MarshalIndent(d.status, "", "  ")
+}
+
+// writeStatus writes the status to the status file, if there is one.
+func (d *daemon) writeStatus() {
+	if d.statusFile == "" {
+		return
+	}
+	bz, err := d.statusJSON()
+	if err == nil {
+		// write and rename so readers never see a partial file
+		tmp := d.statusFile + ".tmp"
+		if err = os.WriteFile(tmp, append(bz, '\n'), 0o644); err == nil {
+			err = os.Rename(tmp, d.statusFile)
+		}
+	}
+	if err != nil {
+		d.logger.Error("failed to write status", "path", d.statusFile, "err", err)
+	}
+}
+
+// serveStatus serves the status as JSON on addr until the returned server is
+// closed.
+func (d *daemon) serveStatus(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			bz, err := d.statusJSON()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(bz)
+		}),
+	}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			d.logger.Error("status server stopped", "err", err)
+		}
+	}()
+	d.logger.Info("serving status", "addr", listener.Addr().String())
+	return srv, nil
+}
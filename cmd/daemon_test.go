@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+func newTestDaemon(t *testing.T, newPruner func(home string) (*pruner.Pruner, error)) (*daemon, string) {
+	dir := t.TempDir()
+	return &daemon{
+		home:       dir,
+		dataDir:    dir,
+		newPruner:  newPruner,
+		stopCmd:    "echo stop >> " + filepath.Join(dir, "node.log"),
+		startCmd:   "echo start >> " + filepath.Join(dir, "node.log"),
+		retries:    2,
+		statusFile: filepath.Join(dir, "status.json"),
+		logger:     log.NewNopLogger(),
+	}, dir
+}
+
+func readStatus(t *testing.T, path string) daemonStatus {
+	bz, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var status daemonStatus
+	require.NoError(t, json.Unmarshal(bz, &status))
+	return status
+}
+
+func TestDaemonWindow(t *testing.T) {
+	d, dir := newTestDaemon(t, func(home string) (*pruner.Pruner, error) {
+		return pruner.New(pruner.Options{DataDir: home, SkipBlocks: true, SkipApp: true})
+	})
+
+	require.NoError(t, d.window(context.Background(), "schedule"))
+	nodeLog, err := os.ReadFile(filepath.Join(dir, "node.log"))
+	require.NoError(t, err)
+	require.Equal(t, "stop\nstart\n", string(nodeLog))
+
+	status := readStatus(t, d.statusFile)
+	require.Equal(t, daemonIdle, status.State)
+	require.Equal(t, "schedule", status.LastRun.Trigger)
+	require.Equal(t, pruner.PhaseDone, status.LastRun.Status)
+	require.Equal(t, 1, status.LastRun.Attempts)
+	require.NotNil(t, status.LastRun.Finished)
+	require.NotNil(t, status.LastRun.Verify)
+}
+
+func TestDaemonWindowRetries(t *testing.T) {
+	d, dir := newTestDaemon(t, func(string) (*pruner.Pruner, error) {
+		return nil, fmt.Errorf("boom")
+	})
+
+	err := d.window(context.Background(), "size")
+	require.ErrorContains(t, err, "prune: boom")
+	// a prune failing before it changed the databases is retried, and the
+	// node started again on them
+	nodeLog, err := os.ReadFile(filepath.Join(dir, "node.log"))
+	require.NoError(t, err)
+	require.Equal(t, "stop\nstart\n", string(nodeLog))
+
+	status := readStatus(t, d.statusFile)
+	require.Equal(t, pruner.PhaseFailed, status.LastRun.Status)
+	require.Equal(t, 3, status.LastRun.Attempts)
+	require.Contains(t, status.LastRun.Error, "boom")
+
+	// a failing stop command leaves the data directory alone
+	d.stopCmd = "exit 1"
+	err = d.window(context.Background(), "schedule")
+	require.ErrorContains(t, err, "stop node")
+	require.Equal(t, 0, readStatus(t, d.statusFile).LastRun.Attempts)
+}
+
+func TestDaemonWindowLeavesNodeStopped(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		db   string
+		opts pruner.Options
+		err  string
+	}{
+		// the app phase starts and fails without an application state
+		{"prune", "", pruner.Options{SkipBlocks: true, KeepVersions: 10}, "prune: "},
+		// nothing to prune, but a block store without blocks does not verify
+		{"verify", "blockstore", pruner.Options{SkipApp: true, KeepBlocks: 10}, "verify: "},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, dir := newTestDaemon(t, func(home string) (*pruner.Pruner, error) {
+				opts := tc.opts
+				opts.DataDir = home
+				return pruner.New(opts)
+			})
+			if tc.db != "" {
+				database, err := db.NewGoLevelDB(tc.db, dir)
+				require.NoError(t, err)
+				require.NoError(t, database.Close())
+			}
+
+			err := d.window(context.Background(), "schedule")
+			require.ErrorIs(t, err, errNodeLeftStopped)
+			require.ErrorContains(t, err, tc.err)
+			// not retried, and the node is not started on the databases
+			nodeLog, err := os.ReadFile(filepath.Join(dir, "node.log"))
+			require.NoError(t, err)
+			require.Equal(t, "stop\n", string(nodeLog))
+
+			status := readStatus(t, d.statusFile)
+			require.Equal(t, pruner.PhaseFailed, status.LastRun.Status)
+			require.Equal(t, 1, status.LastRun.Attempts)
+			require.Contains(t, status.LastRun.Error, errNodeLeftStopped.Error())
+		})
+	}
+}
+
+func TestDaemonSizeBackoff(t *testing.T) {
+	d, dir := newTestDaemon(t, nil)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blob"), make([]byte, 1024), 0o644))
+	d.sizeThreshold, d.checkInterval, d.minInterval = 512, time.Minute, time.Hour
+
+	// pruning left the data directory over the threshold, size triggered runs
+	// come further and further apart
+	d.checkSizeAfterRun()
+	require.Equal(t, 2*time.Hour, d.sizeBackoff)
+	d.checkSizeAfterRun()
+	require.Equal(t, 4*time.Hour, d.sizeBackoff)
+
+	d.sizeThreshold = 1 << 20
+	d.checkSizeAfterRun()
+	require.Zero(t, d.sizeBackoff)
+}
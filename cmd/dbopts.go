@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/spf13/viper"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+// dbOptions are the tunable LevelDB open options. Sizes are strings such as
+// "64MiB", zero values keep the goleveldb defaults.
+type dbOptions struct {
+	BlockCacheCapacity     string
+	WriteBuffer            string
+	CompactionTableSize    string
+	OpenFilesCacheCapacity int
+	BloomFilterBits        int
+}
+
+// dbPresets are option profiles selectable with --db-preset or per database
+// with db-options.<name>.preset in the config file.
+var dbPresets = map[string]dbOptions{
+	"default": {},
+	"low-memory": {
+		BlockCacheCapacity:     "8MiB",
+		WriteBuffer:            "4MiB",
+		CompactionTableSize:    "2MiB",
+		OpenFilesCacheCapacity: 100,
+		BloomFilterBits:        10,
+	},
+	"fast-nvme": {
+		BlockCacheCapacity:     "512MiB",
+		WriteBuffer:            "128MiB",
+		CompactionTableSize:    "32MiB",
+		OpenFilesCacheCapacity: 4096,
+		BloomFilterBits:        10,
+	},
+}
+
+// dbOptionsFor resolves the options of a database: the preset chosen for it,
+// or --db-preset, overridden by any individual entries under db-options.<name>.
+func dbOptionsFor(name string) (dbOptions, error) {
+	key := func(field string) string { return fmt.Sprintf("db-options.%s.%s", name, field) }
+
+	preset := dbPreset
+	if viper.IsSet(key("preset")) {
+		preset = viper.GetString(key("preset"))
+	}
+	o, ok := dbPresets[preset]
+	if !ok {
+		return dbOptions{}, fmt.Errorf("unknown db preset %q for %s (supported: default, low-memory, fast-nvme)", preset, name)
+	}
+
+	if viper.IsSet(key("block-cache-capacity")) {
+		o.BlockCacheCapacity = viper.GetString(key("block-cache-capacity"))
+	}
+	if viper.IsSet(key("write-buffer")) {
+		o.WriteBuffer = viper.GetString(key("write-buffer"))
+	}
+	if viper.IsSet(key("compaction-table-size")) {
+		o.CompactionTableSize = viper.GetString(key("compaction-table-size"))
+	}
+	if viper.IsSet(key("open-files-cache-capacity")) {
+		o.OpenFilesCacheCapacity = viper.GetInt(key("open-files-cache-capacity"))
+	}
+	if viper.IsSet(key("bloom-filter-bits")) {
+		o.BloomFilterBits = viper.GetInt(key("bloom-filter-bits"))
+	}
+	return o, nil
+}
+
+// levelDBOptions converts the resolved options of a database to goleveldb options.
+func levelDBOptions(name string, readOnly bool) (*opt.Options, error) {
+	o, err := dbOptionsFor(name)
+	if err != nil {
+		return nil, err
+	}
+
+	lo := pruner.DefaultLevelDBOptions(readOnly)
+	lo.OpenFilesCacheCapacity = o.OpenFilesCacheCapacity
+	for _, size := range []struct {
+		value string
+		dst   *int
+	}{
+		{o.BlockCacheCapacity, &lo.BlockCacheCapacity},
+		{o.WriteBuffer, &lo.WriteBuffer},
+		{o.CompactionTableSize, &lo.CompactionTableSize},
+	} {
+		if size.value == "" {
+			continue
+		}
+		n, err := throttle.ParseBytes(size.value)
+		if err != nil {
+			return nil, fmt.Errorf("db-options.%s: %w", name, err)
+		}
+		*size.dst = int(n)
+	}
+	if o.BloomFilterBits > 0 {
+		lo.Filter = filter.NewBloomFilter(o.BloomFilterBits)
+	}
+	return lo, nil
+}
+
+// openDB opens the named LevelDB database in dir with its configured options.
+func openDB(name, dir string, readOnly bool) (*db.GoLevelDB, error) {
+	o, err := levelDBOptions(name, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("opening db", "db", name, "block_cache", o.BlockCacheCapacity, "write_buffer", o.WriteBuffer,
+		"compaction_table_size", o.CompactionTableSize, "open_files_cache", o.OpenFilesCacheCapacity)
+	return db.NewGoLevelDBWithOpts(name, dir, o)
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLevelDBOptions(t *testing.T) {
+	defer viper.Reset()
+	dbPreset = "low-memory"
+	defer func() { dbPreset = "default" }()
+
+	viper.Set("db-options.application.preset", "fast-nvme")
+	viper.Set("db-options.application.write-buffer", "16MiB")
+
+	// per db preset with an individual override
+	o, err := levelDBOptions("application", false)
+	require.NoError(t, err)
+	require.Equal(t, 512<<20, o.BlockCacheCapacity)
+	require.Equal(t, 16<<20, o.WriteBuffer)
+	require.Equal(t, 4096, o.OpenFilesCacheCapacity)
+	require.NotNil(t, o.Filter)
+	require.True(t, o.DisableSeeksCompaction)
+
+	// global preset
+	o, err = levelDBOptions("blockstore", true)
+	require.NoError(t, err)
+	require.Equal(t, 8<<20, o.BlockCacheCapacity)
+	require.True(t, o.ReadOnly)
+
+	viper.Set("db-options.state.preset", "unknown")
+	_, err = levelDBOptions("state", false)
+	require.Error(t, err)
+}
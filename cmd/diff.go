@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cosmos/cosmos-sdk/store/dbadapter"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+type diffKind string
+
+const (
+	diffAdded    diffKind = "added"
+	diffRemoved  diffKind = "removed"
+	diffModified diffKind = "modified"
+)
+
+// keyDiff is a single key that differs between two versions of a store.
+type keyDiff struct {
+	Kind     diffKind
+	Key      []byte
+	OldValue []byte
+	NewValue []byte
+}
+
+// errStopDiff can be returned by a diff callback to end the walk early.
+var errStopDiff = fmt.Errorf("stop diff")
+
+// diffCmd prints the keys that changed between two versions of the application store.
+func diffCmd() *cobra.Command {
+	var (
+		from        int64
+		to          int64
+		store       string
+		summaryOnly bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "diff [path_to_home]",
+		Short: "list the keys added, removed and modified between two versions of the application store",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if from <= 0 || to <= 0 {
+				return fmt.Errorf("both --from and --to must be set")
+			}
+
+			appDB, err := openAppDB(args[0], true)
+			if err != nil {
+				return err
+			}
+			defer appDB.Close()
+
+			names := []string{store}
+			if store == "" {
+				if names, err = rootmulti.LatestStoreNames(appDB); err != nil {
+					return err
+				}
+			}
+
+			appStore, err := loadAppStoreAt(appDB, 0, names...)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			counts := make(map[string]map[diffKind]int)
+			for _, name := range names {
+				fromStore, err := diffStoreAt(appStore, name, from)
+				if err != nil {
+					return fmt.Errorf("store %s at version %d: %w", name, from, err)
+				}
+				toStore, err := diffStoreAt(appStore, name, to)
+				if err != nil {
+					return fmt.Errorf("store %s at version %d: %w", name, to, err)
+				}
+				counts[name] = make(map[diffKind]int)
+				err = diffKVStores(fromStore, toStore, func(d keyDiff) error {
+					counts[name][d.Kind]++
+					if !summaryOnly {
+						printKeyDiff(out, name, d)
+					}
+					return nil
+				})
+				if err != nil {
+					return fmt.Errorf("failed to diff store %s: %w", name, err)
+				}
+			}
+
+			fmt.Fprintf(out, "\n%-24s %10s %10s %10s\n", "STORE", "ADDED", "REMOVED", "MODIFIED")
+			sort.Strings(names)
+			for _, name := range names {
+				c := counts[name]
+				fmt.Fprintf(out, "%-24s %10d %10d %10d\n", name, c[diffAdded], c[diffRemoved], c[diffModified])
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Int64Var(&from, "from", 0, "version to diff from")
+	cmd.Flags().Int64Var(&to, "to", 0, "version to diff to")
+	cmd.Flags().StringVar(&store, "store", "", "only diff this store (default all stores of the latest commit info)")
+	cmd.Flags().BoolVar(&summaryOnly, "summary", false, "only print the per store counts")
+
+	return cmd
+}
+
+// diffStoreAt returns the named store at version, or an empty store when the
+// commit info of version does not list it, such as a store added by a later
+// upgrade.
+func diffStoreAt(appStore *rootmulti.Store, name string, version int64) (storetypes.KVStore, error) {
+	commitInfo, err := appStore.GetCommitInfo(version)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range commitInfo.StoreInfos {
+		if info.Name == name {
+			return kvStoreAt(appStore, name, version)
+		}
+	}
+	return dbadapter.Store{DB: dbm.NewMemDB()}, nil
+}
+
+func printKeyDiff(out io.Writer, store string, d keyDiff) {
+	switch d.Kind {
+	case diffAdded:
+		fmt.Fprintf(out, "+ %s %X %X\n", store, d.Key, d.NewValue)
+	case diffRemoved:
+		fmt.Fprintf(out, "- %s %X %X\n", store, d.Key, d.OldValue)
+	case diffModified:
+		fmt.Fprintf(out, "~ %s %X %X -> %X\n", store, d.Key, d.OldValue, d.NewValue)
+	}
+}
+
+// diffKVStores walks both stores in key order and calls fn for every key that
+// differs. Returning errStopDiff from fn ends the walk without an error.
+func diffKVStores(oldStore, newStore storetypes.KVStore, fn func(d keyDiff) error) error {
+	oldItr := oldStore.Iterator(nil, nil)
+	defer oldItr.Close()
+	newItr := newStore.Iterator(nil, nil)
+	defer newItr.Close()
+
+	for oldItr.Valid() || newItr.Valid() {
+		var d keyDiff
+		switch {
+		case !newItr.Valid():
+			d = keyDiff{Kind: diffRemoved, Key: oldItr.Key(), OldValue: oldItr.Value()}
+			oldItr.Next()
+		case !oldItr.Valid():
+			d = keyDiff{Kind: diffAdded, Key: newItr.Key(), NewValue: newItr.Value()}
+			newItr.Next()
+		default:
+			switch c := bytes.Compare(oldItr.Key(), newItr.Key()); {
+			case c < 0:
+				d = keyDiff{Kind: diffRemoved, Key: oldItr.Key(), OldValue: oldItr.Value()}
+				oldItr.Next()
+			case c > 0:
+				d = keyDiff{Kind: diffAdded, Key: newItr.Key(), NewValue: newItr.Value()}
+				newItr.Next()
+			default:
+				oldValue, newValue := oldItr.Value(), newItr.Value()
+				d = keyDiff{Kind: diffModified, Key: oldItr.Key(), OldValue: oldValue, NewValue: newValue}
+				oldItr.Next()
+				newItr.Next()
+				if bytes.Equal(oldValue, newValue) {
+					continue
+				}
+			}
+		}
+
+		if err := fn(d); err == errStopDiff {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// a failed read ends the walk early, the diff is then incomplete
+	if err := oldItr.Error(); err != nil {
+		return err
+	}
+	return newItr.Error()
+}
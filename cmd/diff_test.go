@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cosmos/cosmos-sdk/store/dbadapter"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffKVStores(t *testing.T) {
+	oldStore := dbadapter.Store{DB: dbm.NewMemDB()}
+	newStore := dbadapter.Store{DB: dbm.NewMemDB()}
+
+	oldStore.Set([]byte("a"), []byte("1"))
+	oldStore.Set([]byte("b"), []byte("2"))
+	oldStore.Set([]byte("d"), []byte("4"))
+	newStore.Set([]byte("b"), []byte("2"))
+	newStore.Set([]byte("c"), []byte("3"))
+	newStore.Set([]byte("d"), []byte("5"))
+	newStore.Set([]byte("e"), []byte("6"))
+
+	var diffs []keyDiff
+	err := diffKVStores(oldStore, newStore, func(d keyDiff) error {
+		diffs = append(diffs, d)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []keyDiff{
+		{Kind: diffRemoved, Key: []byte("a"), OldValue: []byte("1")},
+		{Kind: diffAdded, Key: []byte("c"), NewValue: []byte("3")},
+		{Kind: diffModified, Key: []byte("d"), OldValue: []byte("4"), NewValue: []byte("5")},
+		{Kind: diffAdded, Key: []byte("e"), NewValue: []byte("6")},
+	}, diffs)
+
+	// stopping early is not an error
+	diffs = nil
+	err = diffKVStores(oldStore, newStore, func(d keyDiff) error {
+		diffs = append(diffs, d)
+		return errStopDiff
+	})
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+}
+
+// failingStore is a store whose iterators stop at once with a read error.
+type failingStore struct {
+	dbadapter.Store
+}
+
+func (s failingStore) Iterator(start, end []byte) storetypes.Iterator {
+	return failingIterator{s.Store.Iterator(start, end)}
+}
+
+type failingIterator struct {
+	storetypes.Iterator
+}
+
+func (failingIterator) Valid() bool  { return false }
+func (failingIterator) Error() error { return errors.New("read failed") }
+
+func TestDiffKVStoresReadError(t *testing.T) {
+	oldStore := failingStore{dbadapter.Store{DB: dbm.NewMemDB()}}
+	newStore := dbadapter.Store{DB: dbm.NewMemDB()}
+	oldStore.Set([]byte("a"), []byte("1"))
+
+	// a truncated walk is not reported as a complete diff
+	err := diffKVStores(oldStore, newStore, func(keyDiff) error { return nil })
+	require.ErrorContains(t, err, "read failed")
+}
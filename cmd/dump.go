@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/spf13/cobra"
+)
+
+// valueDecoders are the hooks available to render values in dump output.
+// Chains with known encodings can register more decoders here.
+var valueDecoders = map[string]func(value []byte) (string, error){
+	"hex": func(value []byte) (string, error) {
+		return hex.EncodeToString(value), nil
+	},
+	"base64": func(value []byte) (string, error) {
+		return base64.StdEncoding.EncodeToString(value), nil
+	},
+	"utf8": func(value []byte) (string, error) {
+		if !utf8.Valid(value) {
+			return "", fmt.Errorf("value is not valid utf8")
+		}
+		return string(value), nil
+	},
+}
+
+// dumpCmd lists the keys under a prefix of a module store at a retained version.
+func dumpCmd() *cobra.Command {
+	var (
+		prefixHex  string
+		startAfter string
+		height     int64
+		format     string
+		limit      uint64
+		decode     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "dump [path_to_home] [store]",
+		Short: "dump the keys of a module store under a prefix at a retained version",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			storeName := args[1]
+
+			decoder, ok := valueDecoders[decode]
+			if !ok {
+				return fmt.Errorf("unknown value decoder %q", decode)
+			}
+			prefix, err := hex.DecodeString(prefixHex)
+			if err != nil {
+				return fmt.Errorf("invalid prefix: %w", err)
+			}
+			start := prefix
+			if startAfter != "" {
+				after, err := hex.DecodeString(startAfter)
+				if err != nil {
+					return fmt.Errorf("invalid start-after key: %w", err)
+				}
+				start = dumpStart(prefix, after)
+			}
+
+			var w dumpWriter
+			switch format {
+			case "jsonl":
+				w = &jsonlDumpWriter{enc: json.NewEncoder(cmd.OutOrStdout())}
+			case "csv":
+				w = &csvDumpWriter{w: csv.NewWriter(cmd.OutOrStdout())}
+			default:
+				return fmt.Errorf("unknown format %q (supported: jsonl, csv)", format)
+			}
+
+			appDB, err := openAppDB(args[0], true)
+			if err != nil {
+				return err
+			}
+			defer appDB.Close()
+
+			appStore, err := loadAppStoreAt(appDB, 0, storeName)
+			if err != nil {
+				return err
+			}
+			if height == 0 {
+				height = appStore.LatestVersion()
+			}
+			if err := checkStoreCommitted(appStore, height, storeName); err != nil {
+				return err
+			}
+
+			kv, err := kvStoreAt(appStore, storeName, height)
+			if err != nil {
+				return err
+			}
+
+			if len(start) == 0 {
+				// iterate from the first key
+				start = nil
+			}
+			itr := kv.Iterator(start, storetypes.PrefixEndBytes(prefix))
+			defer itr.Close()
+
+			var count uint64
+			for ; itr.Valid(); itr.Next() {
+				if limit > 0 && count == limit {
+					logger.Info("limit reached, continue with --start-after", "next", fmt.Sprintf("%x", itr.Key()))
+					break
+				}
+				value, err := decoder(itr.Value())
+				if err != nil {
+					return fmt.Errorf("failed to decode value of key %x: %w", itr.Key(), err)
+				}
+				if err := w.Write(itr.Key(), value); err != nil {
+					return err
+				}
+				count++
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			logger.Info("dump complete", "store", storeName, "height", height, "keys", count)
+			return itr.Error()
+		},
+	}
+
+	cmd.Flags().StringVar(&prefixHex, "prefix", "", "hex encoded key prefix to dump (default all keys)")
+	cmd.Flags().StringVar(&startAfter, "start-after", "", "hex encoded key to resume after, for pagination")
+	cmd.Flags().Int64Var(&height, "height", 0, "version to dump (default latest)")
+	cmd.Flags().StringVar(&format, "format", "jsonl", "output format (jsonl|csv)")
+	cmd.Flags().Uint64Var(&limit, "limit", 0, "maximum number of keys to output (default no limit)")
+	cmd.Flags().StringVar(&decode, "decode", "hex", "value decoder (hex|base64|utf8)")
+
+	return cmd
+}
+
+// dumpStart returns the first key to dump under prefix when resuming after
+// the given key: the smallest key strictly greater than after, but never one
+// before the prefix.
+func dumpStart(prefix, after []byte) []byte {
+	start := append(after, 0x00)
+	if bytes.Compare(start, prefix) < 0 {
+		return prefix
+	}
+	return start
+}
+
+// dumpWriter writes dumped key/value pairs in an output format.
+type dumpWriter interface {
+	Write(key []byte, value string) error
+	Flush() error
+}
+
+type jsonlDumpWriter struct {
+	enc *json.Encoder
+}
+
+func (w *jsonlDumpWriter) Write(key []byte, value string) error {
+	return w.enc.Encode(struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}{
+		Key:   hex.EncodeToString(key),
+		Value: value,
+	})
+}
+
+func (w *jsonlDumpWriter) Flush() error { return nil }
+
+type csvDumpWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvDumpWriter) Write(key []byte, value string) error {
+	return w.w.Write([]string{hex.EncodeToString(key), value})
+}
+
+func (w *csvDumpWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpStart(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, after, want string
+	}{
+		{"", "ab", "ab\x00"},
+		{"a", "ab", "ab\x00"},
+		// a key sorting before the prefix resumes at the prefix
+		{"b", "a", "b"},
+		{"b", "", "b"},
+		// a key past the prefix leaves nothing to dump
+		{"b", "c", "c\x00"},
+	} {
+		require.Equal(t, []byte(tc.want), dumpStart([]byte(tc.prefix), []byte(tc.after)), "prefix %q after %q", tc.prefix, tc.after)
+	}
+}
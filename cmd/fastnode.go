@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// fastNodeCmd groups the commands managing IAVL fast node indexes.
+func fastNodeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fastnode",
+		Short: "drop or rebuild the IAVL fast node indexes of application stores offline",
+	}
+
+	cmd.AddCommand(
+		fastNodeDropCmd(),
+		fastNodeRebuildCmd(),
+	)
+
+	return cmd
+}
+
+// fastNodeDropCmd deletes the fast nodes and storage version marker of stores.
+func fastNodeDropCmd() *cobra.Command {
+	var (
+		stores []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "drop [path_to_home]",
+		Short: "delete the IAVL fast nodes of application stores and compact their key ranges",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner(args[0])
+			if err != nil {
+				return err
+			}
+			_, err = p.DropFastNodes(cmd.Context(), stores)
+			return err
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&stores, "stores", nil, "stores to drop the fast nodes of (default all stores)")
+
+	return cmd
+}
+
+// fastNodeRebuildCmd regenerates the fast nodes of stores at the latest version.
+func fastNodeRebuildCmd() *cobra.Command {
+	var (
+		stores []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rebuild [path_to_home]",
+		Short: "regenerate the IAVL fast nodes of application stores at the latest version",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner(args[0])
+			if err != nil {
+				return err
+			}
+			return p.RebuildFastNodes(cmd.Context(), stores)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&stores, "stores", nil, "stores to rebuild the fast nodes of (default all stores)")
+
+	return cmd
+}
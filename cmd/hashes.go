@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// hashesCmd prints the per store commit hashes of every retained version.
+func hashesCmd() *cobra.Command {
+	var (
+		from   int64
+		to     int64
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "hashes [path_to_home]",
+		Short: "print the per store commit hashes and app hash of every retained version",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "table" && output != "json" {
+				return fmt.Errorf("unknown output %q (supported: table, json)", output)
+			}
+
+			appDB, err := openAppDB(args[0], true)
+			if err != nil {
+				return err
+			}
+			defer appDB.Close()
+
+			versions, err := rootmulti.CommitInfoVersions(appDB)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			enc := json.NewEncoder(out)
+			if output == "table" {
+				fmt.Fprintf(out, "%-12s %-24s %s\n", "VERSION", "STORE", "HASH")
+			}
+
+			appStore := rootmulti.NewStore(appDB, logger)
+			for _, version := range versions {
+				if (from > 0 && version < from) || (to > 0 && version > to) {
+					continue
+				}
+
+				cInfo, err := appStore.GetCommitInfo(version)
+				if err != nil {
+					return fmt.Errorf("version %d: %w", version, err)
+				}
+
+				if output == "json" {
+					stores := make(map[string]string, len(cInfo.StoreInfos))
+					for _, info := range cInfo.StoreInfos {
+						stores[info.Name] = hex.EncodeToString(info.CommitId.Hash)
+					}
+					err := enc.Encode(struct {
+						Version int64             `json:"version"`
+						AppHash string            `json:"app_hash"`
+						Stores  map[string]string `json:"stores"`
+					}{
+						Version: version,
+						AppHash: hex.EncodeToString(cInfo.Hash()),
+						Stores:  stores,
+					})
+					if err != nil {
+						return err
+					}
+					continue
+				}
+
+				for _, info := range cInfo.StoreInfos {
+					fmt.Fprintf(out, "%-12d %-24s %X\n", version, info.Name, info.CommitId.Hash)
+				}
+				fmt.Fprintf(out, "%-12d %-24s %X\n", version, "<app hash>", cInfo.Hash())
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Int64Var(&from, "from", 0, "first version to print (default earliest)")
+	cmd.Flags().Int64Var(&to, "to", 0, "last version to print (default latest)")
+	cmd.Flags().StringVar(&output, "output", "table", "output format (table|json)")
+
+	return cmd
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// legacyCmd lists the application stores that are still in the IAVL
+// v0.19/v0.20 layout, and optionally migrates them to the v1 layout.
+func legacyCmd() *cobra.Command {
+	var (
+		migrate bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "legacy [path_to_home]",
+		Short: "list the application stores in the legacy IAVL layout of v0.19 and v0.20, and migrate them to the v1 layout",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner(args[0])
+			if err != nil {
+				return err
+			}
+
+			stores, err := p.LegacyStores()
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "%-24s %-16s %-16s %-12s %s\n", "STORE", "DB", "LEGACY_VERSIONS", "LEGACY_NODES", "V1_LATEST")
+			var legacy []string
+			for _, s := range stores {
+				versions := "-"
+				if s.LatestVersion > 0 {
+					versions = fmt.Sprintf("%d-%d", s.FirstVersion, s.LatestVersion)
+				}
+				fmt.Fprintf(out, "%-24s %-16s %-16s %-12t %d\n", s.Name, s.DB, versions, s.LegacyNodes, s.V1LatestVersion)
+				if s.Legacy() {
+					legacy = append(legacy, s.Name)
+				}
+			}
+
+			if !migrate || len(legacy) == 0 {
+				return nil
+			}
+			return p.MigrateLegacy(cmd.Context(), legacy)
+		},
+	}
+
+	cmd.Flags().BoolVar(&migrate, "migrate", false, "migrate the stores in the legacy layout to the v1 layout")
+
+	return cmd
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
+)
+
+// orphansCmd lists, and optionally deletes, the data of stores that are no
+// longer part of the application, such as modules removed by a store upgrade.
+func orphansCmd() *cobra.Command {
+	var (
+		deleteOrphans bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "orphans [path_to_home]",
+		Short: "list the stores in application.db that are absent from every retained commit info, with their sizes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner(args[0])
+			if err != nil {
+				return err
+			}
+
+			var orphans []pruner.OrphanStore
+			if deleteOrphans {
+				orphans, err = p.DeleteOrphanStores(cmd.Context())
+			} else {
+				orphans, err = p.OrphanStores()
+			}
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "%-32s %s\n", "STORE", "SIZE")
+			var total int64
+			for _, o := range orphans {
+				fmt.Fprintf(out, "%-32s %d\n", o.Name, o.Size)
+				total += o.Size
+			}
+			fmt.Fprintf(out, "%-32s %d\n", "TOTAL", total)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&deleteOrphans, "delete", false, "delete the orphaned stores and compact their key ranges")
+
+	return cmd
+}
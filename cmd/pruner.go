@@ -1,40 +1,13 @@
 package cmd
 
 import (
-	"context"
-	"fmt"
 	"path/filepath"
+	"strings"
 
-	"github.com/cometbft/cometbft/state"
-	"github.com/cosmos/cosmos-sdk/types"
-	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
-	authzkeeper "github.com/cosmos/cosmos-sdk/x/authz/keeper"
-	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
-	capabilitytypes "github.com/cosmos/cosmos-sdk/x/capability/types"
-	packetforwardtypes "github.com/cosmos/ibc-apps/middleware/packet-forward-middleware/v7/packetforward/types"
-	icqtypes "github.com/cosmos/ibc-apps/modules/async-icq/v7/types"
-	icahosttypes "github.com/cosmos/ibc-go/v7/modules/apps/27-interchain-accounts/host/types"
-	ibctransfertypes "github.com/cosmos/ibc-go/v7/modules/apps/transfer/types"
-	ibchost "github.com/cosmos/ibc-go/v7/modules/core/exported"
-
-	db "github.com/cometbft/cometbft-db"
-	tmstore "github.com/cometbft/cometbft/store"
-	storetypes "github.com/cosmos/cosmos-sdk/store/types"
-	consensusparamtypes "github.com/cosmos/cosmos-sdk/x/consensus/types"
-	crisistypes "github.com/cosmos/cosmos-sdk/x/crisis/types"
-	distrtypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
-	evidencetypes "github.com/cosmos/cosmos-sdk/x/evidence/types"
-	govtypes "github.com/cosmos/cosmos-sdk/x/gov/types"
-	minttypes "github.com/cosmos/cosmos-sdk/x/mint/types"
-	paramstypes "github.com/cosmos/cosmos-sdk/x/params/types"
-	slashingtypes "github.com/cosmos/cosmos-sdk/x/slashing/types"
-	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
-	upgradetypes "github.com/cosmos/cosmos-sdk/x/upgrade/types"
-	"github.com/neilotoole/errgroup"
 	"github.com/spf13/cobra"
-	"github.com/syndtr/goleveldb/leveldb/opt"
 
-	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+	"github.com/binaryholdings/cosmos-pruner/pkg/pruner"
 )
 
 // load db
@@ -42,230 +15,93 @@ import (
 // if immutable tree is not deletable we should import and export current state
 
 func pruneCmd() *cobra.Command {
+	var (
+		dryRun     bool
+		reportPath string
+	)
+
 	cmd := &cobra.Command{
 		Use:   "prune [path_to_home]",
 		Short: "prune data from the application store and block store",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-
-			logger.Info("Starting pruning...")
-
-			ctx := cmd.Context()
-			errs, _ := errgroup.WithContext(ctx)
-			var err error
-
-			// Tendermint pruning (blockstore.db, state.db)
-			if tendermint {
-				errs.Go(func() error {
-					if err = pruneTMData(args[0]); err != nil {
-						return err
-					}
-					return nil
-				})
+			p, err := newPruner(args[0])
+			if err != nil {
+				return err
 			}
 
-			if cosmosSdk {
-				err = pruneAppState(args[0])
+			if dryRun {
+				// only print the disk space preflight
+				plan, err := p.Plan(cmd.Context())
 				if err != nil {
 					return err
 				}
+				if _, err := plan.Check(minFree); err != nil {
+					return err
+				}
+				logger.Info("dry run, nothing was pruned")
 				return nil
-
 			}
 
-			return errs.Wait()
+			logger.Info("Starting pruning...")
+			report, err := p.Run(cmd.Context())
+			if reportPath != "" {
+				if reportErr := report.WriteFile(reportPath); reportErr != nil {
+					logger.Error("failed to write report", "path", reportPath, "err", reportErr)
+				}
+			}
+			return err
 		},
 	}
-	return cmd
-}
-
-func pruneAppState(home string) error {
-
-	// this has the potential to expand size, should just use state sync
-	// dbType := db.BackendType(backend)
-
-	dbDir := rootify(dataDir, home)
-
-	o := opt.Options{
-		DisableSeeksCompaction: true,
-	}
-
-	// Get BlockStore
-	appDB, err := db.NewGoLevelDBWithOpts("application", dbDir, &o)
-	if err != nil {
-		return err
-	}
-
-	//TODO: need to get all versions in the store, setting randomly is too slow
-	logger.Info("pruning application state")
-
-	// only mount keys from core sdk
-	// todo allow for other keys to be mounted
-	keys := types.NewKVStoreKeys(
-		authtypes.StoreKey, banktypes.StoreKey, authzkeeper.StoreKey, stakingtypes.StoreKey, distrtypes.StoreKey, slashingtypes.StoreKey, ibchost.StoreKey,
-		icahosttypes.StoreKey,
-		icqtypes.StoreKey,
-		evidencetypes.StoreKey, minttypes.StoreKey, govtypes.StoreKey, ibctransfertypes.StoreKey,
-		packetforwardtypes.StoreKey,
-		paramstypes.StoreKey, consensusparamtypes.StoreKey, capabilitytypes.StoreKey, crisistypes.StoreKey, upgradetypes.StoreKey,
-		// feegrant.StoreKey,
-	)
-
-	if app == "osmosis" {
-		osmoKeys := types.NewKVStoreKeys(
-			"downtimedetector",
-			"hooks-for-ibc",
-			"lockup", //lockuptypes.StoreKey,
-			"concentratedliquidity",
-			"gamm", // gammtypes.StoreKey,
-			"cosmwasmpool",
-			"poolmanager",
-			"twap",
-			"epochs", // epochstypes.StoreKey,
-			"protorev",
-			"txfees",         // txfeestypes.StoreKey,
-			"incentives",     // incentivestypes.StoreKey,
-			"poolincentives", //poolincentivestypes.StoreKey,
-			"tokenfactory",   //tokenfactorytypes.StoreKey,
-			"valsetpref",
-			"superfluid", // superfluidtypes.StoreKey,
-			"wasm",       // wasm.StoreKey,
-			//"rate-limited-ibc", // there is no store registered for this module
-		)
-		for key, value := range osmoKeys {
-			keys[key] = value
-		}
-	}
-
-	// TODO: cleanup app state
-	appStore := rootmulti.NewStore(appDB, logger)
-
-	// Configure IAVL fast node
-	// Default (false): fast node enabled for queries
-	// With flag (true): fast node disabled for faster pruning
-	appStore.SetIAVLDisableFastNode(disableFastNode)
-	if disableFastNode {
-		logger.Info("IAVL fast node disabled (faster pruning mode)")
-	} else {
-		logger.Info("IAVL fast node enabled (default mode)")
-	}
-
-	for _, value := range keys {
-		appStore.MountStoreWithDB(value, storetypes.StoreTypeIAVL, nil)
-	}
-
-	err = appStore.LoadLatestVersion()
-	if err != nil {
-		return err
-	}
-
-	latestHeight := rootmulti.GetLatestVersion(appDB)
-	// valid heights should be greater than 0.
-	if latestHeight <= 0 {
-		return fmt.Errorf("the database has no valid heights to prune, the latest height: %v", latestHeight)
-	}
 
-	// var pruningHeights []int64
-	// for height := int64(1); height < latestHeight; height++ {
-	// 	if height < latestHeight-int64(versions) {
-	// 		pruningHeights = append(pruningHeights, height)
-	// 	}
-	// }
-
-	// Prune the last X versions
-	// This is the most efficient way to prune the application state
-	// as it only needs to delete the last X versions
-	pruneHeight := latestHeight - int64(versions)
-	if pruneHeight <= 0 {
-		logger.Error("no heights to prune")
-		return nil
-	}
-	pruningHeights := []int64{pruneHeight}
-	//pruningHeight := []int64{latestHeight - int64(versions)}
-
-	if err = appStore.PruneStores(false, pruningHeights); err != nil {
-		return err
-	}
-	logger.Info("pruning application state complete")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only print the disk space preflight, do not prune")
+	cmd.Flags().StringVar(&reportPath, "report", "", "write the status of every prune phase to this file as JSON")
 
-	logger.Info("compacting application state")
-	if err := appDB.Compact(nil, nil); err != nil {
-		return err
-	}
-	logger.Info("compacting application state complete")
-
-	//create a new app store
-	return nil
+	return cmd
 }
 
-// pruneTMData prunes the tendermint blocks and state based on the amount of blocks to keep
-func pruneTMData(home string) error {
-
-	dbDir := rootify(dataDir, home)
-
-	o := opt.Options{
-		DisableSeeksCompaction: true,
-	}
-
-	// Get BlockStore
-	blockStoreDB, err := db.NewGoLevelDBWithOpts("blockstore", dbDir, &o)
-	if err != nil {
-		return err
-	}
-	blockStore := tmstore.NewBlockStore(blockStoreDB)
-
-	base := blockStore.Base()
-
-	pruneHeight := blockStore.Height() - int64(blocks)
-
-	// Check if there's anything to prune
-	if pruneHeight <= base {
-		logger.Error("no blocks to prune", "base", base, "target", pruneHeight)
-		return nil
-	} // Get StateStore
-
-	errs, _ := errgroup.WithContext(context.Background())
-	errs.Go(func() error {
-		logger.Info("pruning block store")
-		// prune block store
-		blocks, err = blockStore.PruneBlocks(pruneHeight)
-		if err != nil {
-			return err
-		}
-		logger.Info("pruning block store complete")
-
-		logger.Info("compacting block store")
-		if err := blockStoreDB.Compact(nil, nil); err != nil {
-			return err
+// newPruner returns a pruner for the data directory of home configured from
+// the flags.
+func newPruner(home string) (*pruner.Pruner, error) {
+	var (
+		budget int64
+		err    error
+	)
+	if memoryBudget != "" {
+		if budget, err = throttle.ParseBytes(memoryBudget); err != nil {
+			return nil, err
 		}
-		logger.Info("compacting block store complete")
-
-		return nil
-	})
-
-	logger.Info("pruning state store")
-	stateDB, err := db.NewGoLevelDBWithOpts("state", dbDir, &o)
-	if err != nil {
-		return err
 	}
 
-	stateStore := state.NewStore(stateDB, state.StoreOptions{
-		DiscardABCIResponses: true,
+	return pruner.New(pruner.Options{
+		DataDir:           rootify(dataDir, home),
+		App:               app,
+		KeepBlocks:        blocks,
+		KeepVersions:      versions,
+		SkipBlocks:        !tendermint,
+		SkipApp:           !cosmosSdk,
+		Strategy:          pruner.Strategy(pruneStrategy),
+		CopyThreshold:     copyThreshold,
+		MinFreeSpace:      minFree,
+		IORateLimit:       ioRateLimit,
+		LevelDBOptions:    levelDBOptions,
+		Concurrency:       concurrency,
+		IAVLCacheSize:     iavlCacheSize,
+		MemoryBudget:      budget,
+		KeepFastNodes:     !disableFastNode,
+		FastNodeWhitelist: fastNodeWhitelist,
+		MigrateLegacy:     migrateLegacy,
+		StoreDBs:          storeDBs,
+		MemIAVLKeepRecent: int(memIAVLKeepRecent),
+		Wasm:              pruneWasm,
+		WasmDir:           wasmDir,
+		Logger:            logger,
 	})
-	// prune state store
-	err = stateStore.PruneStates(base, pruneHeight)
-	if err != nil {
-		return err
-	}
-	logger.Info("pruning state store complete")
-
-	logger.Info("compacting state store")
-	if err := stateDB.Compact(nil, nil); err != nil {
-		return err
-	}
-	logger.Info("compacting state store complete")
+}
 
-	return nil
+// supportedApps returns the names of the app profiles, for flag help.
+func supportedApps() string {
+	return strings.Join(pruner.Apps(), ", ")
 }
 
 // Utils
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/merkle"
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// queryCmd reads a single raw key from a module store of a stopped node.
+func queryCmd() *cobra.Command {
+	var (
+		height   int64
+		prove    bool
+		encoding string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "query [path_to_home] [store] [key]",
+		Short: "query a raw key from the application store at a retained version",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			storeName := args[1]
+			key, err := decodeBytes(args[2], encoding)
+			if err != nil {
+				return err
+			}
+
+			appDB, err := openAppDB(args[0], true)
+			if err != nil {
+				return err
+			}
+			defer appDB.Close()
+
+			appStore, err := loadAppStoreAt(appDB, 0, storeName)
+			if err != nil {
+				return err
+			}
+
+			// iavl defaults to latest-1 when no height is given, we want the latest
+			if height == 0 {
+				height = appStore.LatestVersion()
+			}
+			// iavl answers queries at missing versions with an empty value, make sure it is retained
+			if _, err := appStore.CacheMultiStoreWithVersion(height); err != nil {
+				return fmt.Errorf("height %d is not retained: %w", height, err)
+			}
+			if err := checkStoreCommitted(appStore, height, storeName); err != nil {
+				return err
+			}
+
+			res := appStore.Query(abci.RequestQuery{
+				Path:   fmt.Sprintf("/%s/key", storeName),
+				Data:   key,
+				Height: height,
+				Prove:  prove,
+			})
+			if !res.IsOK() {
+				return fmt.Errorf("query failed with code %d: %s", res.Code, res.Log)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "height: %d\n", res.Height)
+			fmt.Fprintf(out, "store:  %s\n", storeName)
+			fmt.Fprintf(out, "key:    %X\n", key)
+			if res.Value == nil {
+				fmt.Fprintln(out, "value:  <nil>")
+			} else {
+				fmt.Fprintf(out, "value:  %X\n", res.Value)
+			}
+
+			if !prove {
+				return nil
+			}
+
+			commitInfo, err := appStore.GetCommitInfo(res.Height)
+			if err != nil {
+				return err
+			}
+			appHash := commitInfo.Hash()
+			keyPath := merkle.KeyPath{}.
+				AppendKey([]byte(storeName), merkle.KeyEncodingURL).
+				AppendKey(key, merkle.KeyEncodingHex).
+				String()
+
+			prt := rootmulti.DefaultProofRuntime()
+			if res.Value == nil {
+				err = prt.VerifyAbsence(res.ProofOps, appHash, keyPath)
+			} else {
+				err = prt.VerifyValue(res.ProofOps, appHash, keyPath, res.Value)
+			}
+			for _, op := range res.ProofOps.Ops {
+				fmt.Fprintf(out, "proof:  %s %X\n", op.Type, op.Data)
+			}
+			fmt.Fprintf(out, "app hash: %X\n", appHash)
+			if err != nil {
+				return fmt.Errorf("proof verification failed: %w", err)
+			}
+			fmt.Fprintln(out, "proof verified")
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Int64Var(&height, "height", 0, "version to query (default latest)")
+	cmd.Flags().BoolVar(&prove, "prove", false, "emit and verify the merkle proof against the app hash")
+	cmd.Flags().StringVar(&encoding, "encoding", "hex", "encoding of the key argument (hex|base64|utf8)")
+
+	return cmd
+}
+
+// checkStoreCommitted returns an error if the named store is not in the
+// commit info of version. A store that was never committed loads empty, so
+// reads from it would silently return nothing.
+func checkStoreCommitted(appStore *rootmulti.Store, version int64, name string) error {
+	commitInfo, err := appStore.GetCommitInfo(version)
+	if err != nil {
+		return err
+	}
+	for _, info := range commitInfo.StoreInfos {
+		if info.Name == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("store %s is not in the commit info at height %d", name, version)
+}
+
+// decodeBytes decodes a user supplied key in the given encoding.
+func decodeBytes(s, encoding string) ([]byte, error) {
+	switch encoding {
+	case "hex":
+		return hex.DecodeString(s)
+	case "base64":
+		return base64.StdEncoding.DecodeString(s)
+	case "utf8":
+		return []byte(s), nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q (supported: hex, base64, utf8)", encoding)
+	}
+}
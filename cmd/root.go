@@ -6,6 +6,8 @@ import (
 	"github.com/cometbft/cometbft/libs/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
 )
 
 var (
@@ -18,9 +20,26 @@ var (
 	versions        uint64
 	debug           bool
 	disableFastNode bool
+	concurrency     int
+	ioRateLimit     string
+	cfgFile         string
+
+	iavlCacheSize     int
+	fastNodeWhitelist []string
+	memoryBudget      string
+	dbPreset          string
+	minFreeSpace      string
+	pruneStrategy     string
+	copyThreshold     float64
+	migrateLegacy     bool
+	pruneWasm         bool
+	wasmDir           string
+	storeDBs          map[string]string
+	memIAVLKeepRecent uint64
 
 	appName = "cosmprund"
 	logger  log.Logger
+	minFree int64
 )
 
 // NewRootCmd returns the root command for relayer.
@@ -32,10 +51,10 @@ func NewRootCmd() *cobra.Command {
 	}
 
 	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
-		// reads `homeDir/config.yaml` into `var config *Config` before each command
-		// if err := initConfig(rootCmd); err != nil {
-		// 	return err
-		// }
+		// reads the --config file into the flag variables before each command
+		if err := initConfig(); err != nil {
+			return err
+		}
 		logger = log.NewTMLogger(log.NewSyncWriter(os.Stdout))
 		// Set log level based on debug flag
 		if debug {
@@ -45,9 +64,69 @@ func NewRootCmd() *cobra.Command {
 			// Only show Info level and above (hides Debug logs like loadVersion commitID)
 			logger = log.NewFilter(logger, log.AllowInfo())
 		}
-		return nil
+
+		var err error
+		minFree, err = throttle.ParseBytes(minFreeSpace)
+		return err
+	}
+
+	// --prune-strategy flag
+	rootCmd.PersistentFlags().StringVar(&pruneStrategy, "prune-strategy", "auto", "how to prune the block and state stores: delete pruned heights, copy retained heights into a fresh db, or auto to copy when --copy-threshold or less is retained")
+	if err := viper.BindPFlag("prune-strategy", rootCmd.PersistentFlags().Lookup("prune-strategy")); err != nil {
+		panic(err)
+	}
+
+	// --copy-threshold flag
+	rootCmd.PersistentFlags().Float64Var(&copyThreshold, "copy-threshold", 0.1, "with --prune-strategy=auto, the largest retained fraction of a db that is copied forward instead of deleted")
+	if err := viper.BindPFlag("copy-threshold", rootCmd.PersistentFlags().Lookup("copy-threshold")); err != nil {
+		panic(err)
+	}
+
+	// --migrate-legacy flag
+	rootCmd.PersistentFlags().BoolVar(&migrateLegacy, "migrate-legacy", false, "migrate application stores written by IAVL v0.19/v0.20 to the IAVL v1 layout after pruning them (default false)")
+	if err := viper.BindPFlag("migrate-legacy", rootCmd.PersistentFlags().Lookup("migrate-legacy")); err != nil {
+		panic(err)
+	}
+
+	// --store-db flag
+	rootCmd.PersistentFlags().StringToStringVar(&storeDBs, "store-db", nil, "stores kept in a database of their own in the data directory, as store=db, e.g. wasm=wasm.db (default the ones of the app)")
+	if err := viper.BindPFlag("store-db", rootCmd.PersistentFlags().Lookup("store-db")); err != nil {
+		panic(err)
+	}
+
+	// --memiavl-keep-recent flag
+	rootCmd.PersistentFlags().Uint64Var(&memIAVLKeepRecent, "memiavl-keep-recent", 1, "memiavl snapshots to keep besides the one in use (default 1)")
+	if err := viper.BindPFlag("memiavl-keep-recent", rootCmd.PersistentFlags().Lookup("memiavl-keep-recent")); err != nil {
+		panic(err)
+	}
+
+	// --wasm flag
+	rootCmd.PersistentFlags().BoolVar(&pruneWasm, "wasm", false, "remove stale compiled wasm module caches, check the wasm code blobs against the wasm store and report the wasm directory size (default false)")
+	if err := viper.BindPFlag("wasm", rootCmd.PersistentFlags().Lookup("wasm")); err != nil {
+		panic(err)
+	}
+
+	// --wasm-dir flag
+	rootCmd.PersistentFlags().StringVar(&wasmDir, "wasm-dir", "", "wasm directory of the node (default wasm next to the data directory)")
+	if err := viper.BindPFlag("wasm-dir", rootCmd.PersistentFlags().Lookup("wasm-dir")); err != nil {
+		panic(err)
+	}
+
+	// --db-preset flag
+	rootCmd.PersistentFlags().StringVar(&dbPreset, "db-preset", "default", "leveldb option preset for all databases (default|low-memory|fast-nvme), see db-options in the config to tune each database")
+	if err := viper.BindPFlag("db-preset", rootCmd.PersistentFlags().Lookup("db-preset")); err != nil {
+		panic(err)
+	}
+
+	// --min-free-space flag
+	rootCmd.PersistentFlags().StringVar(&minFreeSpace, "min-free-space", "1GiB", "free disk space to keep while pruning and compacting (default 1GiB)")
+	if err := viper.BindPFlag("min-free-space", rootCmd.PersistentFlags().Lookup("min-free-space")); err != nil {
+		panic(err)
 	}
 
+	// --config flag
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "path to a config file (yaml, toml or json) with entries named like the flags")
+
 	// --blocks flag
 	rootCmd.PersistentFlags().Uint64VarP(&blocks, "blocks", "b", 10, "set the amount of blocks to keep (default=10)")
 	if err := viper.BindPFlag("blocks", rootCmd.PersistentFlags().Lookup("blocks")); err != nil {
@@ -67,7 +146,7 @@ func NewRootCmd() *cobra.Command {
 	}
 
 	// --app flag
-	rootCmd.PersistentFlags().StringVar(&app, "app", "osmosis", "set the app you are pruning (supported apps: osmosis)")
+	rootCmd.PersistentFlags().StringVar(&app, "app", "osmosis", "set the app you are pruning (supported apps: "+supportedApps()+")")
 	if err := viper.BindPFlag("app", rootCmd.PersistentFlags().Lookup("app")); err != nil {
 		panic(err)
 	}
@@ -91,13 +170,55 @@ func NewRootCmd() *cobra.Command {
 	}
 
 	// --disable-fast-node flag
-	rootCmd.PersistentFlags().BoolVar(&disableFastNode, "disable-fast-node", true, "disable IAVL fast node for faster pruning (default: false, fast node enabled)")
+	rootCmd.PersistentFlags().BoolVar(&disableFastNode, "disable-fast-node", true, "disable IAVL fast node for faster pruning, set to false to keep fast nodes (default true)")
 	if err := viper.BindPFlag("disable-fast-node", rootCmd.PersistentFlags().Lookup("disable-fast-node")); err != nil {
 		panic(err)
 	}
 
+	// --fast-node-whitelist flag
+	rootCmd.PersistentFlags().StringSliceVar(&fastNodeWhitelist, "fast-node-whitelist", nil, "only keep IAVL fast nodes for these stores, requires --disable-fast-node=false (default all stores)")
+	if err := viper.BindPFlag("fast-node-whitelist", rootCmd.PersistentFlags().Lookup("fast-node-whitelist")); err != nil {
+		panic(err)
+	}
+
+	// --iavl-cache-size flag
+	rootCmd.PersistentFlags().IntVar(&iavlCacheSize, "iavl-cache-size", 0, "IAVL node cache size per store (default 0, derived from --memory-budget)")
+	if err := viper.BindPFlag("iavl-cache-size", rootCmd.PersistentFlags().Lookup("iavl-cache-size")); err != nil {
+		panic(err)
+	}
+
+	// --memory-budget flag
+	rootCmd.PersistentFlags().StringVar(&memoryBudget, "memory-budget", "", "memory the IAVL caches of all stores may use, e.g. 8GiB (default half of the available memory)")
+	if err := viper.BindPFlag("memory-budget", rootCmd.PersistentFlags().Lookup("memory-budget")); err != nil {
+		panic(err)
+	}
+
+	// --concurrency flag
+	rootCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 0, "number of stores loaded and pruned at once (default 0, all stores)")
+	if err := viper.BindPFlag("concurrency", rootCmd.PersistentFlags().Lookup("concurrency")); err != nil {
+		panic(err)
+	}
+
+	// --io-rate-limit flag
+	rootCmd.PersistentFlags().StringVar(&ioRateLimit, "io-rate-limit", "", "write and compaction budget per second, in bytes (e.g. 64MiB) or ops (e.g. 2000ops) (default unlimited)")
+	if err := viper.BindPFlag("io-rate-limit", rootCmd.PersistentFlags().Lookup("io-rate-limit")); err != nil {
+		panic(err)
+	}
+
 	rootCmd.AddCommand(
 		pruneCmd(),
+		verifyCmd(),
+		daemonCmd(),
+		queryCmd(),
+		dumpCmd(),
+		diffCmd(),
+		compareCmd(),
+		hashesCmd(),
+		compactCmd(),
+		orphansCmd(),
+		upgradeStoresCmd(),
+		fastNodeCmd(),
+		legacyCmd(),
 	)
 
 	return rootCmd
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cosmos/cosmos-sdk/store/iavl"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// openAppDB opens application.db in the data directory of home. Commands that
+// only inspect state should pass readOnly so a stopped node's DB is never modified.
+func openAppDB(home string, readOnly bool) (*db.GoLevelDB, error) {
+	return openDB("application", rootify(dataDir, home), readOnly)
+}
+
+// loadAppStoreAt mounts the named IAVL stores and loads the multistore at the
+// given version, or at the latest version when version is 0. Fast nodes are
+// disabled so loading never triggers an IAVL storage upgrade.
+func loadAppStoreAt(appDB db.DB, version int64, names ...string) (*rootmulti.Store, error) {
+	return rootmulti.LoadStoresAt(appDB, logger, nil, version, names...)
+}
+
+// kvStoreAt returns a read only view of the named IAVL store of appStore at a
+// retained version. Unlike the stores of a cache multistore, whose iterators
+// report an error once exhausted, its iterators only report failed reads.
+func kvStoreAt(appStore *rootmulti.Store, name string, version int64) (storetypes.KVStore, error) {
+	store, ok := appStore.GetCommitKVStore(appStore.StoreKeysByName()[name]).(*iavl.Store)
+	if !ok {
+		return nil, fmt.Errorf("store %s is not an IAVL store", name)
+	}
+	return store.GetImmutable(version)
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	db "github.com/cometbft/cometbft-db"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/spf13/cobra"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// upgradeStoresCmd applies store upgrades to application.db offline and
+// commits them as a new version.
+func upgradeStoresCmd() *cobra.Command {
+	var (
+		added        []string
+		renamed      []string
+		deleted      []string
+		upgradesFile string
+		force        bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "upgrade-stores [path_to_home]",
+		Short: "add, rename and delete application stores offline and commit them as a new version",
+		Long: `Add, rename and delete application stores offline, the way an upgrade handler's
+StoreUpgrades would, and commit the result as a new version.
+
+The upgrades are given with flags or as a JSON file in the StoreUpgrades format:
+
+  {"added": ["x"], "renamed": [{"old_key": "a", "new_key": "b"}], "deleted": ["y"]}
+
+The commit bumps the application to the latest version + 1, one height ahead of
+the block store and state. CometBFT refuses to start such a node with an app
+height mismatch in the handshake until its block store and state are brought
+to that height, e.g. by a state sync or a restore from a snapshot, so the
+command only runs with --force.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			upgrades := &storetypes.StoreUpgrades{Added: added, Deleted: deleted}
+			for _, r := range renamed {
+				oldName, newName, ok := strings.Cut(r, ":")
+				if !ok || oldName == "" || newName == "" {
+					return fmt.Errorf("invalid rename %q, expected old:new", r)
+				}
+				upgrades.Renamed = append(upgrades.Renamed, storetypes.StoreRename{OldKey: oldName, NewKey: newName})
+			}
+			if upgradesFile != "" {
+				bz, err := os.ReadFile(upgradesFile)
+				if err != nil {
+					return err
+				}
+				var fromFile storetypes.StoreUpgrades
+				if err := json.Unmarshal(bz, &fromFile); err != nil {
+					return fmt.Errorf("failed to parse %s: %w", upgradesFile, err)
+				}
+				upgrades.Added = append(upgrades.Added, fromFile.Added...)
+				upgrades.Renamed = append(upgrades.Renamed, fromFile.Renamed...)
+				upgrades.Deleted = append(upgrades.Deleted, fromFile.Deleted...)
+			}
+			if len(upgrades.Added) == 0 && len(upgrades.Renamed) == 0 && len(upgrades.Deleted) == 0 {
+				return fmt.Errorf("no store upgrades given")
+			}
+			if !force {
+				return fmt.Errorf("the upgrades are committed as the next version, one height ahead of the block store and state, " +
+					"and the node fails its handshake until they are at that height; pass --force to commit them anyway")
+			}
+
+			appDB, err := openAppDB(args[0], false)
+			if err != nil {
+				return err
+			}
+			defer appDB.Close()
+
+			version, err := upgradeStores(appDB, upgrades)
+			if err != nil {
+				return err
+			}
+			logger.Info("store upgrades applied, the node starts once its block store and state are at the version", "version", version)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&added, "added", nil, "stores to add")
+	cmd.Flags().StringSliceVar(&renamed, "renamed", nil, "stores to rename, as old:new")
+	cmd.Flags().StringSliceVar(&deleted, "deleted", nil, "stores to delete")
+	cmd.Flags().StringVar(&upgradesFile, "file", "", "JSON file with the store upgrades, in the StoreUpgrades format")
+	cmd.Flags().BoolVar(&force, "force", false, "commit the upgrades as the next version although that leaves the application one height ahead of the block store and state, which fails the CometBFT handshake until they are at that height")
+
+	return cmd
+}
+
+// upgradeStores loads the latest version of application.db with the store
+// upgrades applied, commits it as the next version and returns that version.
+func upgradeStores(appDB db.DB, upgrades *storetypes.StoreUpgrades) (int64, error) {
+	latest := rootmulti.GetLatestVersion(appDB)
+	names, err := rootmulti.LatestStoreNames(appDB)
+	if err != nil {
+		return 0, err
+	}
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+
+	// the stores of the new version: the existing ones, without the old
+	// names of renamed stores, plus the added ones and the new names
+	mounted := make(map[string]bool, len(names))
+	for _, name := range names {
+		mounted[name] = true
+	}
+	for _, name := range upgrades.Added {
+		if existing[name] {
+			return 0, fmt.Errorf("store %s to add already exists", name)
+		}
+		mounted[name] = true
+	}
+	for _, r := range upgrades.Renamed {
+		if !existing[r.OldKey] {
+			return 0, fmt.Errorf("store %s to rename does not exist", r.OldKey)
+		}
+		if existing[r.NewKey] {
+			return 0, fmt.Errorf("store %s to rename %s to already exists", r.NewKey, r.OldKey)
+		}
+		delete(mounted, r.OldKey)
+		mounted[r.NewKey] = true
+	}
+	for _, name := range upgrades.Deleted {
+		if !existing[name] {
+			return 0, fmt.Errorf("store %s to delete does not exist", name)
+		}
+	}
+
+	cInfo, err := rootmulti.NewStore(appDB, logger).GetCommitInfo(latest)
+	if err != nil {
+		return 0, err
+	}
+
+	appStore := rootmulti.NewStore(appDB, logger)
+	appStore.SetIAVLDisableFastNode(disableFastNode)
+	for name := range mounted {
+		appStore.MountStoreWithDB(storetypes.NewKVStoreKey(name), storetypes.StoreTypeIAVL, nil)
+	}
+
+	logger.Info("applying store upgrades", "version", latest, "added", upgrades.Added, "renamed", upgrades.Renamed, "deleted", upgrades.Deleted)
+	if err := appStore.LoadLatestVersionAndUpgrade(upgrades); err != nil {
+		return 0, err
+	}
+
+	appStore.SetCommitHeader(cmtproto.Header{Height: latest + 1, Time: cInfo.Timestamp})
+	commitID := appStore.Commit()
+	logger.Info("committed store upgrades", "version", commitID.Version, "app_hash", fmt.Sprintf("%X", commitID.Hash))
+
+	// the new commit info lists exactly the upgraded stores
+	newInfo, err := rootmulti.NewStore(appDB, logger).GetCommitInfo(commitID.Version)
+	if err != nil {
+		return 0, err
+	}
+	var want, got []string
+	for name := range mounted {
+		if !upgrades.IsDeleted(name) {
+			want = append(want, name)
+		}
+	}
+	for _, info := range newInfo.StoreInfos {
+		got = append(got, info.Name)
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		return 0, fmt.Errorf("committed stores %v, expected %v", got, want)
+	}
+	return commitID.Version, nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+func TestUpgradeStores(t *testing.T) {
+	logger = log.NewNopLogger()
+	appDB := db.NewMemDB()
+
+	appStore := rootmulti.NewStore(appDB, logger)
+	keys := make(map[string]*storetypes.KVStoreKey)
+	for _, name := range []string{"bank", "staking", "acc"} {
+		keys[name] = storetypes.NewKVStoreKey(name)
+		appStore.MountStoreWithDB(keys[name], storetypes.StoreTypeIAVL, nil)
+	}
+	require.NoError(t, appStore.LoadLatestVersion())
+	for v := int64(1); v <= 3; v++ {
+		appStore.SetCommitHeader(cmtproto.Header{Height: v})
+		for name, key := range keys {
+			appStore.GetKVStore(key).Set([]byte("key"), []byte(name))
+		}
+		appStore.Commit()
+	}
+
+	version, err := upgradeStores(appDB, &storetypes.StoreUpgrades{
+		Added:   []string{"wasm"},
+		Renamed: []storetypes.StoreRename{{OldKey: "bank", NewKey: "bank2"}},
+		Deleted: []string{"staking"},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 4, version)
+
+	names, err := rootmulti.LatestStoreNames(appDB)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"acc", "bank2", "wasm"}, names)
+
+	upgraded, err := loadAppStoreAt(appDB, 0, names...)
+	require.NoError(t, err)
+	require.Equal(t, []byte("bank"), upgraded.GetKVStore(upgraded.StoreKeysByName()["bank2"]).Get([]byte("key")))
+
+	// stores to rename or delete have to exist
+	_, err = upgradeStores(appDB, &storetypes.StoreUpgrades{Deleted: []string{"staking"}})
+	require.Error(t, err)
+}
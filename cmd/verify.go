@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd checks, read only, that a pruned data directory is usable.
+func verifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify [path_to_home]",
+		Short: "check that the retained blocks, states and application versions load, and that the stores match the latest commit info",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newPruner(args[0])
+			if err != nil {
+				return err
+			}
+			result, err := p.Verify(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if result.BlockHeight > 0 {
+				fmt.Fprintf(out, "blocks   %d-%d, state at %d\n", result.BlockBase, result.BlockHeight, result.StateHeight)
+			}
+			if result.LatestVersion > 0 {
+				fmt.Fprintf(out, "versions %d-%d, %d stores\n", result.EarliestVersion, result.LatestVersion, result.Stores)
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
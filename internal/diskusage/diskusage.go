@@ -0,0 +1,50 @@
+// Package diskusage measures the disk space of databases and directories.
+package diskusage
+
+import (
+	"io/fs"
+	"path/filepath"
+	"syscall"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// PrefixSize returns the approximate on-disk size of the keys under prefix.
+func PrefixSize(database *db.GoLevelDB, prefix []byte) (int64, error) {
+	sizes, err := database.DB().SizeOf([]util.Range{*util.BytesPrefix(prefix)})
+	if err != nil {
+		return 0, err
+	}
+	return sizes.Sum(), nil
+}
+
+// DirSize returns the total size of the regular files under dir.
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// Free returns the bytes available to unprivileged users on the filesystem
+// holding dir.
+func Free(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
@@ -0,0 +1,484 @@
+// Package legacyiavl prunes and migrates IAVL stores written in the layout of
+// IAVL v0.19 and v0.20. IAVL v1 reads that layout, but it does not prune it:
+// versions are only deleted once every legacy version is, and then in the
+// background. The functions here work on the db of a single store, with the
+// store prefix already applied.
+//
+// The legacy layout keys nodes by hash (n<hash>), records the nodes a version
+// orphaned (o<last version><first version><hash>) and the root of every
+// version (r<version>). The v1 layout keys nodes by the version that created
+// them and a nonce (s<version><nonce>), the root of a version being nonce 1.
+package legacyiavl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+// key prefixes within a store
+const (
+	nodePrefix   = 'n'
+	orphanPrefix = 'o'
+	rootPrefix   = 'r'
+	v1NodePrefix = 's'
+	// migrationPrefix holds the state of a migration in progress
+	migrationPrefix = 'x'
+)
+
+const (
+	hashSize      = 32
+	nodeKeySize   = 12
+	orphanKeySize = 1 + 8 + 8 + hashSize
+
+	// the mode of a v1 inner node flags the children referenced by hash
+	modeLegacyLeft  = 0x01
+	modeLegacyRight = 0x02
+
+	rootNonce uint32 = 1
+
+	// batchSize is the number of writes per batch
+	batchSize = 10000
+)
+
+// Info describes the layout of a store.
+type Info struct {
+	// Versions is the number of versions with a legacy root.
+	Versions int64
+	// FirstVersion and LatestVersion are the first and the latest versions
+	// with a legacy root, 0 when there are none.
+	FirstVersion  int64
+	LatestVersion int64
+	// LegacyNodes is set when nodes in the legacy layout remain, which is the
+	// case for stores that IAVL v1 wrote on top of legacy versions even once
+	// the legacy versions are gone.
+	LegacyNodes bool
+	// V1FirstVersion and V1LatestVersion are the first and the latest
+	// versions in the v1 layout, 0 when there are none.
+	V1FirstVersion  int64
+	V1LatestVersion int64
+}
+
+// Legacy reports whether any part of the store is in the legacy layout.
+func (i Info) Legacy() bool {
+	return i.Versions > 0 || i.LegacyNodes
+}
+
+// EarliestVersion returns the first version with a root in either layout, 0
+// when there is none. Legacy versions all precede the v1 ones.
+func (i Info) EarliestVersion() int64 {
+	if i.Versions > 0 {
+		return i.FirstVersion
+	}
+	return i.V1FirstVersion
+}
+
+// Stats counts the keys a cleanup or migration removed and converted.
+type Stats struct {
+	// Roots, Orphans and Nodes are the legacy roots, orphan records and
+	// nodes deleted.
+	Roots   int
+	Orphans int
+	Nodes   int
+	// Converted is the number of legacy nodes written in the v1 layout, and
+	// Rewritten the number of v1 nodes whose legacy child references were
+	// replaced by v1 node keys.
+	Converted int
+	Rewritten int
+}
+
+// Add returns the sum of two Stats.
+func (s Stats) Add(o Stats) Stats {
+	return Stats{
+		Roots:     s.Roots + o.Roots,
+		Orphans:   s.Orphans + o.Orphans,
+		Nodes:     s.Nodes + o.Nodes,
+		Converted: s.Converted + o.Converted,
+		Rewritten: s.Rewritten + o.Rewritten,
+	}
+}
+
+// Inspect returns the layout of a store.
+func Inspect(db dbm.DB) (Info, error) {
+	var info Info
+
+	itr, err := dbm.IteratePrefix(db, []byte{rootPrefix})
+	if err != nil {
+		return info, err
+	}
+	for ; itr.Valid(); itr.Next() {
+		version := int64(binary.BigEndian.Uint64(itr.Key()[1:]))
+		if info.Versions == 0 {
+			info.FirstVersion = version
+		}
+		info.LatestVersion = version
+		info.Versions++
+	}
+	err = itr.Error()
+	itr.Close()
+	if err != nil {
+		return info, err
+	}
+
+	if info.LegacyNodes, err = hasPrefix(db, []byte{nodePrefix}); err != nil {
+		return info, err
+	}
+
+	rev, err := db.ReverseIterator(v1NodeKey(1, 0)[:9], v1NodeKey(math.MaxInt64, 0)[:9])
+	if err != nil {
+		return info, err
+	}
+	defer rev.Close()
+	if rev.Valid() {
+		info.V1LatestVersion = int64(binary.BigEndian.Uint64(rev.Key()[1:]))
+	}
+	if err := rev.Error(); err != nil || info.V1LatestVersion == 0 {
+		return info, err
+	}
+	info.V1FirstVersion, err = v1FirstVersion(db, info.V1LatestVersion)
+	return info, err
+}
+
+// v1FirstVersion returns the first version up to latest with a v1 root. The
+// retained versions are contiguous, since pruning deletes the oldest first,
+// so it bisects on whether the root of a version exists.
+func v1FirstVersion(db dbm.DB, latest int64) (int64, error) {
+	lo, hi := int64(1), latest
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		ok, err := db.Has(v1NodeKey(mid, rootNonce))
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
+
+// hasPrefix reports whether any key starts with prefix.
+func hasPrefix(db dbm.DB, prefix []byte) (bool, error) {
+	itr, err := dbm.IteratePrefix(db, prefix)
+	if err != nil {
+		return false, err
+	}
+	defer itr.Close()
+	return itr.Valid(), itr.Error()
+}
+
+// node is a decoded IAVL node of either layout. The children are hashes for
+// legacy nodes, and either hashes or v1 node keys for v1 nodes.
+type node struct {
+	height  int8
+	size    int64
+	version int64
+	key     []byte
+	value   []byte
+	hash    []byte
+	left    []byte
+	right   []byte
+}
+
+func (n *node) isLeaf() bool {
+	return n.height == 0
+}
+
+// decodeLegacyNode decodes a node stored under n<hash>: height, size,
+// version, key, then the value of a leaf or the hashes of the children.
+func decodeLegacyNode(hash, buf []byte) (*node, error) {
+	n := &node{hash: hash}
+	r := reader{buf: buf}
+	n.height = int8(r.varint())
+	n.size = r.varint()
+	n.version = r.varint()
+	n.key = r.bytes()
+	if n.isLeaf() {
+		n.value = r.bytes()
+	} else {
+		n.left = r.bytes()
+		n.right = r.bytes()
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("decoding legacy node %X: %w", hash, r.err)
+	}
+	return n, nil
+}
+
+// decodeNode decodes a node stored under s<node key>: height, size, key, then
+// the value of a leaf or the hash, a mode flagging legacy children and the
+// children. The version comes from the node key.
+func decodeNode(nodeKey, buf []byte) (*node, error) {
+	n := &node{version: int64(binary.BigEndian.Uint64(nodeKey))}
+	r := reader{buf: buf}
+	n.height = int8(r.varint())
+	n.size = r.varint()
+	n.key = r.bytes()
+	if n.isLeaf() {
+		n.value = r.bytes()
+	} else {
+		n.hash = r.bytes()
+		mode := r.varint()
+		child := func(legacy bool) []byte {
+			if legacy {
+				return r.bytes()
+			}
+			version := r.varint()
+			nonce := r.varint()
+			return makeNodeKey(version, uint32(nonce))
+		}
+		n.left = child(mode&modeLegacyLeft != 0)
+		n.right = child(mode&modeLegacyRight != 0)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("decoding node %X: %w", nodeKey, r.err)
+	}
+	return n, nil
+}
+
+// encodeNode encodes a node in the v1 layout, flagging the children that are
+// still referenced by hash.
+func encodeNode(n *node) []byte {
+	var buf []byte
+	buf = binary.AppendVarint(buf, int64(n.height))
+	buf = binary.AppendVarint(buf, n.size)
+	buf = appendBytes(buf, n.key)
+	if n.isLeaf() {
+		return appendBytes(buf, n.value)
+	}
+
+	buf = appendBytes(buf, n.hash)
+	var mode int64
+	if len(n.left) == hashSize {
+		mode |= modeLegacyLeft
+	}
+	if len(n.right) == hashSize {
+		mode |= modeLegacyRight
+	}
+	buf = binary.AppendVarint(buf, mode)
+	for _, child := range [][]byte{n.left, n.right} {
+		if len(child) == hashSize {
+			buf = appendBytes(buf, child)
+			continue
+		}
+		buf = binary.AppendVarint(buf, int64(binary.BigEndian.Uint64(child)))
+		buf = binary.AppendVarint(buf, int64(binary.BigEndian.Uint32(child[8:])))
+	}
+	return buf
+}
+
+func appendBytes(buf, bz []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(bz)))
+	return append(buf, bz...)
+}
+
+// reader decodes the varints and length prefixed byte slices of a node,
+// keeping the first error.
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errors.New("invalid varint")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	if r.err != nil {
+		return nil
+	}
+	size, n := binary.Uvarint(r.buf)
+	if n <= 0 || size > uint64(len(r.buf)-n) {
+		r.err = errors.New("invalid length prefixed bytes")
+		return nil
+	}
+	bz := r.buf[n : n+int(size)]
+	r.buf = r.buf[n+int(size):]
+	return bz
+}
+
+func makeNodeKey(version int64, nonce uint32) []byte {
+	nk := make([]byte, nodeKeySize)
+	binary.BigEndian.PutUint64(nk, uint64(version))
+	binary.BigEndian.PutUint32(nk[8:], nonce)
+	return nk
+}
+
+func v1NodeKey(version int64, nonce uint32) []byte {
+	return append([]byte{v1NodePrefix}, makeNodeKey(version, nonce)...)
+}
+
+func legacyNodeKey(hash []byte) []byte {
+	return append([]byte{nodePrefix}, hash...)
+}
+
+func legacyRootKey(version int64) []byte {
+	key := make([]byte, 9)
+	key[0] = rootPrefix
+	binary.BigEndian.PutUint64(key[1:], uint64(version))
+	return key
+}
+
+// legacyRoot returns the hash of the root of a legacy version, which is empty
+// for an empty tree.
+func legacyRoot(db dbm.DB, version int64) ([]byte, error) {
+	hash, err := db.Get(legacyRootKey(version))
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		return nil, fmt.Errorf("legacy version %d does not exist", version)
+	}
+	return hash, nil
+}
+
+// v1Root returns the node key of the root of a v1 version, following the
+// reference a version whose tree did not change keeps to an earlier root. It
+// returns nil for an empty tree.
+func v1Root(db dbm.DB, version int64) ([]byte, error) {
+	rootKey := makeNodeKey(version, rootNonce)
+	val, err := db.Get(v1NodeKey(version, rootNonce))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case val == nil:
+		return nil, fmt.Errorf("version %d does not exist", version)
+	case len(val) == 0:
+		return nil, nil
+	case !isReference(val):
+		return rootKey, nil
+	}
+
+	ref := val[1:]
+	if len(ref) == 8 {
+		// references written before the lazy pruning omit the nonce
+		return makeNodeKey(int64(binary.BigEndian.Uint64(ref)), rootNonce), nil
+	}
+	if ok, err := db.Has(val); err != nil || ok {
+		return ref, err
+	}
+	// pruning moves the root of a pruned version that is still referenced
+	// to nonce 0
+	return makeNodeKey(int64(binary.BigEndian.Uint64(ref)), 0), nil
+}
+
+// isReference reports whether the value of a v1 root key is a reference to
+// the root of an earlier version rather than a node. Nodes start with their
+// zigzag encoded height, which is never the odd byte 's'.
+func isReference(val []byte) bool {
+	return val[0] == v1NodePrefix && (len(val) == 1+nodeKeySize || len(val) == 1+8)
+}
+
+// getNode loads a node by hash from the legacy layout or by node key from
+// the v1 layout.
+func getNode(db dbm.DB, ref []byte) (*node, error) {
+	if len(ref) == hashSize {
+		buf, err := db.Get(legacyNodeKey(ref))
+		if err != nil {
+			return nil, err
+		}
+		if buf == nil {
+			return nil, fmt.Errorf("legacy node %X is missing", ref)
+		}
+		return decodeLegacyNode(ref, buf)
+	}
+
+	buf, err := db.Get(append([]byte{v1NodePrefix}, ref...))
+	if err != nil {
+		return nil, err
+	}
+	if buf == nil {
+		return nil, fmt.Errorf("node %X is missing", ref)
+	}
+	return decodeNode(ref, buf)
+}
+
+// writer batches writes, checking ctx each time a batch is written.
+type writer struct {
+	ctx     context.Context
+	db      dbm.DB
+	batch   dbm.Batch
+	pending int
+	// beforeWrite, if set, adds to the batch right before it is written
+	beforeWrite func() error
+}
+
+func newWriter(ctx context.Context, db dbm.DB) *writer {
+	return &writer{ctx: ctx, db: db, batch: db.NewBatch()}
+}
+
+func (w *writer) set(key, value []byte) error {
+	if err := w.batch.Set(key, value); err != nil {
+		return err
+	}
+	return w.added()
+}
+
+func (w *writer) delete(key []byte) error {
+	if err := w.batch.Delete(key); err != nil {
+		return err
+	}
+	return w.added()
+}
+
+func (w *writer) added() error {
+	if w.pending++; w.pending < batchSize {
+		return nil
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.ctx.Err()
+}
+
+func (w *writer) flush() error {
+	if w.beforeWrite != nil {
+		if err := w.beforeWrite(); err != nil {
+			return err
+		}
+	}
+	if err := w.batch.Write(); err != nil {
+		return err
+	}
+	w.batch.Close()
+	w.batch, w.pending = w.db.NewBatch(), 0
+	return nil
+}
+
+func (w *writer) close() {
+	w.batch.Close()
+}
+
+// deletePrefix deletes every key starting with prefix and returns how many.
+func (w *writer) deletePrefix(prefix []byte) (int, error) {
+	itr, err := dbm.IteratePrefix(w.db, prefix)
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	var deleted int
+	for ; itr.Valid(); itr.Next() {
+		if err := w.delete(itr.Key()); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, itr.Error()
+}
@@ -0,0 +1,356 @@
+package legacyiavl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	"github.com/cosmos/cosmos-sdk/store/iavl"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/stretchr/testify/require"
+)
+
+// testVersion is the contents and hash of a committed version.
+type testVersion struct {
+	hash     []byte
+	contents map[string]string
+}
+
+// commitVersions commits versions to the store in db, changing a few keys
+// each version and none in the versions listed in unchanged.
+func commitVersions(t *testing.T, db dbm.DB, from, to int64, versions map[int64]testVersion, unchanged ...int64) {
+	store, err := iavl.LoadStore(db, log.NewNopLogger(), storetypes.NewKVStoreKey("test"), storetypes.CommitID{}, 0, true)
+	require.NoError(t, err)
+
+	contents := map[string]string{}
+	if prev, ok := versions[from-1]; ok {
+		for k, v := range prev.contents {
+			contents[k] = v
+		}
+	}
+	for version := from; version <= to; version++ {
+		skip := false
+		for _, u := range unchanged {
+			skip = skip || u == version
+		}
+		for i := int64(0); i < 8 && !skip; i++ {
+			key := fmt.Sprintf("key%03d", (version*5+i*7)%40)
+			if i == 7 && version%3 == 0 {
+				store.Delete([]byte(key))
+				delete(contents, key)
+				continue
+			}
+			value := fmt.Sprintf("value-%d-%d", version, i)
+			store.Set([]byte(key), []byte(value))
+			contents[key] = value
+		}
+		id := store.Commit()
+		require.Equal(t, version, id.Version)
+
+		snapshot := make(map[string]string, len(contents))
+		for k, v := range contents {
+			snapshot[k] = v
+		}
+		versions[version] = testVersion{hash: id.Hash, contents: snapshot}
+	}
+}
+
+// toLegacy rewrites the versions of a v1 store in the legacy layout, with the
+// orphan records IAVL v0.20 keeps, and deletes the v1 keys.
+func toLegacy(t *testing.T, db dbm.DB, latest int64) {
+	trees := make([]map[string]*node, latest+1)
+	for version := int64(1); version <= latest; version++ {
+		trees[version] = map[string]*node{}
+		rootKey, err := v1Root(db, version)
+		require.NoError(t, err)
+		var walk func(ref []byte) []byte
+		walk = func(ref []byte) []byte {
+			n, err := getNode(db, ref)
+			require.NoError(t, err)
+			if n.isLeaf() {
+				n.hash = leafHash(n)
+			} else {
+				n.left, n.right = walk(n.left), walk(n.right)
+			}
+			trees[version][string(n.hash)] = n
+			return n.hash
+		}
+		var rootHash []byte
+		if rootKey != nil {
+			rootHash = walk(rootKey)
+		}
+		require.NoError(t, db.Set(legacyRootKey(version), append([]byte{}, rootHash...)))
+	}
+
+	itr, err := dbm.IteratePrefix(db, []byte{v1NodePrefix})
+	require.NoError(t, err)
+	var v1Keys [][]byte
+	for ; itr.Valid(); itr.Next() {
+		v1Keys = append(v1Keys, append([]byte(nil), itr.Key()...))
+	}
+	itr.Close()
+	for _, key := range v1Keys {
+		require.NoError(t, db.Delete(key))
+	}
+
+	for version := int64(1); version <= latest; version++ {
+		for hash, n := range trees[version] {
+			require.NoError(t, db.Set(legacyNodeKey([]byte(hash)), encodeLegacyNode(n)))
+			if version < latest && trees[version+1][hash] == nil {
+				key := make([]byte, orphanKeySize)
+				key[0] = orphanPrefix
+				binary.BigEndian.PutUint64(key[1:], uint64(version))
+				binary.BigEndian.PutUint64(key[9:], uint64(n.version))
+				copy(key[17:], hash)
+				require.NoError(t, db.Set(key, []byte{}))
+			}
+		}
+	}
+}
+
+func encodeLegacyNode(n *node) []byte {
+	var buf []byte
+	buf = binary.AppendVarint(buf, int64(n.height))
+	buf = binary.AppendVarint(buf, n.size)
+	buf = binary.AppendVarint(buf, n.version)
+	buf = appendBytes(buf, n.key)
+	if n.isLeaf() {
+		return appendBytes(buf, n.value)
+	}
+	buf = appendBytes(buf, n.left)
+	return appendBytes(buf, n.right)
+}
+
+func leafHash(n *node) []byte {
+	valueHash := sha256.Sum256(n.value)
+	var buf []byte
+	buf = binary.AppendVarint(buf, 0)
+	buf = binary.AppendVarint(buf, 1)
+	buf = binary.AppendVarint(buf, n.version)
+	buf = appendBytes(buf, n.key)
+	buf = appendBytes(buf, valueHash[:])
+	hash := sha256.Sum256(buf)
+	return hash[:]
+}
+
+// requireVersions loads the store with IAVL v1 and checks the hash and
+// contents of the versions.
+func requireVersions(t *testing.T, db dbm.DB, versions map[int64]testVersion, from, to int64) {
+	store, err := iavl.LoadStore(db, log.NewNopLogger(), storetypes.NewKVStoreKey("test"), storetypes.CommitID{}, 0, true)
+	require.NoError(t, err)
+	for version := from; version <= to; version++ {
+		tree, err := store.(*iavl.Store).GetImmutable(version)
+		require.NoError(t, err, "version %d", version)
+		require.Equal(t, versions[version].hash, tree.LastCommitID().Hash, "version %d", version)
+
+		contents := map[string]string{}
+		itr := tree.Iterator(nil, nil)
+		for ; itr.Valid(); itr.Next() {
+			contents[string(itr.Key())] = string(itr.Value())
+		}
+		itr.Close()
+		require.Equal(t, versions[version].contents, contents, "version %d", version)
+	}
+}
+
+// requireNoLeaks checks that every node on disk belongs to one of the
+// versions from on.
+func requireNoLeaks(t *testing.T, db dbm.DB, from, to int64) {
+	reachable := map[string]bool{}
+	var walk func(ref []byte)
+	walk = func(ref []byte) {
+		key := legacyNodeKey(ref)
+		if len(ref) != hashSize {
+			key = append([]byte{v1NodePrefix}, ref...)
+		}
+		if reachable[string(key)] {
+			return
+		}
+		reachable[string(key)] = true
+		n, err := getNode(db, ref)
+		require.NoError(t, err)
+		if !n.isLeaf() {
+			walk(n.left)
+			walk(n.right)
+		}
+	}
+	for version := from; version <= to; version++ {
+		root, err := db.Get(legacyRootKey(version))
+		require.NoError(t, err)
+		if root == nil {
+			root, err = v1Root(db, version)
+			require.NoError(t, err)
+		}
+		if len(root) > 0 {
+			walk(root)
+		}
+	}
+
+	for _, prefix := range []byte{nodePrefix, v1NodePrefix} {
+		itr, err := dbm.IteratePrefix(db, []byte{prefix})
+		require.NoError(t, err)
+		for ; itr.Valid(); itr.Next() {
+			if prefix == v1NodePrefix && (len(itr.Value()) == 0 || isReference(itr.Value())) {
+				continue
+			}
+			require.True(t, reachable[string(itr.Key())], "unreachable node %X", itr.Key())
+		}
+		itr.Close()
+	}
+}
+
+func countPrefix(t *testing.T, db dbm.DB, prefix byte) int {
+	itr, err := dbm.IteratePrefix(db, []byte{prefix})
+	require.NoError(t, err)
+	defer itr.Close()
+	var n int
+	for ; itr.Valid(); itr.Next() {
+		n++
+	}
+	return n
+}
+
+func TestDeleteVersionsToLegacy(t *testing.T) {
+	ctx := context.Background()
+	db := dbm.NewPrefixDB(dbm.NewMemDB(), []byte("s/k:test/"))
+	versions := map[int64]testVersion{}
+	commitVersions(t, db, 1, 10, versions, 8)
+	toLegacy(t, db, 10)
+
+	info, err := Inspect(db)
+	require.NoError(t, err)
+	require.Equal(t, Info{Versions: 10, FirstVersion: 1, LatestVersion: 10, LegacyNodes: true}, info)
+	requireVersions(t, db, versions, 1, 10)
+
+	stats, err := DeleteVersionsTo(ctx, db, 6)
+	require.NoError(t, err)
+	require.Equal(t, 6, stats.Roots)
+	require.Positive(t, stats.Orphans)
+	require.Equal(t, stats.Orphans, stats.Nodes)
+
+	info, err = Inspect(db)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), info.FirstVersion)
+	requireVersions(t, db, versions, 7, 10)
+	requireNoLeaks(t, db, 7, 10)
+
+	// the latest legacy version can not go without a later version
+	_, err = DeleteVersionsTo(ctx, db, 10)
+	require.Error(t, err)
+
+	stats, err = Migrate(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 4, stats.Roots)
+	require.Equal(t, stats.Converted, stats.Nodes)
+	require.Zero(t, stats.Rewritten)
+	for _, prefix := range []byte{nodePrefix, orphanPrefix, rootPrefix, migrationPrefix} {
+		require.Zero(t, countPrefix(t, db, prefix), "prefix %c", prefix)
+	}
+	requireVersions(t, db, versions, 7, 10)
+	requireNoLeaks(t, db, 7, 10)
+
+	// IAVL v1 prunes the migrated store on its own
+	store, err := iavl.LoadStore(db, log.NewNopLogger(), storetypes.NewKVStoreKey("test"), storetypes.CommitID{}, 0, true)
+	require.NoError(t, err)
+	require.NoError(t, store.(*iavl.Store).DeleteVersionsTo(8))
+	requireVersions(t, db, versions, 9, 10)
+	requireNoLeaks(t, db, 9, 10)
+
+	info, err = Inspect(db)
+	require.NoError(t, err)
+	require.Equal(t, int64(9), info.V1FirstVersion)
+	require.Equal(t, int64(9), info.EarliestVersion())
+}
+
+func TestDeleteVersionsToMixed(t *testing.T) {
+	ctx := context.Background()
+	db := dbm.NewPrefixDB(dbm.NewMemDB(), []byte("s/k:test/"))
+	versions := map[int64]testVersion{}
+	commitVersions(t, db, 1, 6, versions)
+	toLegacy(t, db, 6)
+	// IAVL v1 writes the later versions on top of the legacy ones
+	commitVersions(t, db, 7, 12, versions, 7)
+
+	info, err := Inspect(db)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), info.LatestVersion)
+	require.Equal(t, int64(12), info.V1LatestVersion)
+	requireVersions(t, db, versions, 1, 12)
+
+	// cutting into the legacy versions leaves the v1 versions alone
+	stats, err := DeleteVersionsTo(ctx, db, 3)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Roots)
+	requireVersions(t, db, versions, 4, 12)
+	requireNoLeaks(t, db, 4, 12)
+
+	// cutting past them removes every legacy version
+	stats, err = DeleteVersionsTo(ctx, db, 8)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Roots)
+	require.Positive(t, stats.Nodes)
+	info, err = Inspect(db)
+	require.NoError(t, err)
+	require.Zero(t, info.Versions)
+	require.True(t, info.LegacyNodes)
+
+	store, err := iavl.LoadStore(db, log.NewNopLogger(), storetypes.NewKVStoreKey("test"), storetypes.CommitID{}, 0, true)
+	require.NoError(t, err)
+	require.NoError(t, store.(*iavl.Store).DeleteVersionsTo(8))
+	requireVersions(t, db, versions, 9, 12)
+	requireNoLeaks(t, db, 9, 12)
+
+	// the legacy nodes the v1 versions still use are converted
+	stats, err = Migrate(ctx, db)
+	require.NoError(t, err)
+	require.Positive(t, stats.Converted)
+	require.Positive(t, stats.Rewritten)
+	require.Equal(t, stats.Converted, stats.Nodes)
+	info, err = Inspect(db)
+	require.NoError(t, err)
+	require.False(t, info.Legacy())
+	requireVersions(t, db, versions, 9, 12)
+	requireNoLeaks(t, db, 9, 12)
+}
+
+func TestMigrateResumes(t *testing.T) {
+	ctx := context.Background()
+	db := dbm.NewPrefixDB(dbm.NewMemDB(), []byte("s/k:test/"))
+	versions := map[int64]testVersion{}
+	commitVersions(t, db, 1, 5, versions, 3)
+	toLegacy(t, db, 5)
+
+	// a migration interrupted after converting a part of the versions
+	m, err := newMigrator(ctx, db)
+	require.NoError(t, err)
+	hash, err := legacyRoot(db, 2)
+	require.NoError(t, err)
+	_, err = m.convert(hash, 2)
+	require.NoError(t, err)
+	require.NoError(t, m.w.flush())
+	m.w.close()
+
+	stats, err := Migrate(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 5, stats.Roots)
+	requireVersions(t, db, versions, 1, 5)
+	requireNoLeaks(t, db, 1, 5)
+
+	// node keys are unique per version, with nonce 1 the root
+	itr, err := dbm.IteratePrefix(db, []byte{v1NodePrefix})
+	require.NoError(t, err)
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		nk := itr.Key()[1:]
+		if binary.BigEndian.Uint32(nk[8:]) == rootNonce && !isReference(itr.Value()) {
+			root, err := v1Root(db, int64(binary.BigEndian.Uint64(nk)))
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(nk, root))
+		}
+	}
+}
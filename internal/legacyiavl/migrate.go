@@ -0,0 +1,260 @@
+package legacyiavl
+
+import (
+	"context"
+	"encoding/binary"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+// Migrate rewrites the legacy versions and nodes of a store in the v1 layout
+// and deletes the legacy keys, leaving a store IAVL v1 prunes on its own.
+//
+// Every legacy node is written under the version that created it: the root of
+// a legacy version as nonce 1 of that version, like IAVL v1 would, and any
+// other node under the next free nonce from 2 on. v1 nodes that point at
+// legacy nodes by hash are rewritten to point at the converted nodes. Node
+// hashes do not change.
+//
+// The node keys given so far and the next free nonces are kept under x
+// until the legacy keys are deleted, so an interrupted migration resumes
+// where it stopped when run again.
+func Migrate(ctx context.Context, db dbm.DB) (Stats, error) {
+	m, err := newMigrator(ctx, db)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer m.w.close()
+
+	if err := m.convertVersions(); err != nil {
+		return m.stats, err
+	}
+	if err := m.rewriteV1Nodes(); err != nil {
+		return m.stats, err
+	}
+	if err := m.w.flush(); err != nil {
+		return m.stats, err
+	}
+
+	// the roots go first, as without them the store is read in the v1 layout
+	for _, prefix := range []byte{rootPrefix, orphanPrefix, nodePrefix, migrationPrefix} {
+		deleted, err := m.w.deletePrefix([]byte{prefix})
+		if err != nil {
+			return m.stats, err
+		}
+		switch prefix {
+		case rootPrefix:
+			m.stats.Roots += deleted
+		case orphanPrefix:
+			m.stats.Orphans += deleted
+		case nodePrefix:
+			m.stats.Nodes += deleted
+		}
+		if err := m.w.flush(); err != nil {
+			return m.stats, err
+		}
+	}
+	return m.stats, nil
+}
+
+type migrator struct {
+	db    dbm.DB
+	w     *writer
+	stats Stats
+	// nonces is the last nonce given per version
+	nonces map[int64]uint32
+	// converted holds the node keys given since the last batch was written
+	converted map[string][]byte
+	// dirty holds the versions whose nonce changed since then
+	dirty map[int64]bool
+}
+
+func newMigrator(ctx context.Context, db dbm.DB) (*migrator, error) {
+	m := &migrator{
+		db:        db,
+		w:         newWriter(ctx, db),
+		nonces:    make(map[int64]uint32),
+		converted: make(map[string][]byte),
+		dirty:     make(map[int64]bool),
+	}
+	m.w.beforeWrite = m.saveNonces
+
+	itr, err := dbm.IteratePrefix(db, []byte{migrationPrefix})
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		if key := itr.Key(); len(key) == 1+8 {
+			m.nonces[int64(binary.BigEndian.Uint64(key[1:]))] = binary.BigEndian.Uint32(itr.Value())
+		}
+	}
+	return m, itr.Error()
+}
+
+// saveNonces adds the nonces given since the last batch to it, so the
+// batch holds the nodes and the nonces they used.
+func (m *migrator) saveNonces() error {
+	for version := range m.dirty {
+		key := make([]byte, 1+8)
+		key[0] = migrationPrefix
+		binary.BigEndian.PutUint64(key[1:], uint64(version))
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, m.nonces[version])
+		if err := m.w.batch.Set(key, value); err != nil {
+			return err
+		}
+	}
+	m.dirty = make(map[int64]bool)
+	m.converted = make(map[string][]byte)
+	return nil
+}
+
+// convertVersions converts the trees of the legacy versions in order and
+// writes their v1 roots.
+func (m *migrator) convertVersions() error {
+	type root struct {
+		version int64
+		hash    []byte
+	}
+	var roots []root
+	itr, err := dbm.IteratePrefix(m.db, []byte{rootPrefix})
+	if err != nil {
+		return err
+	}
+	for ; itr.Valid(); itr.Next() {
+		roots = append(roots, root{
+			version: int64(binary.BigEndian.Uint64(itr.Key()[1:])),
+			hash:    append([]byte(nil), itr.Value()...),
+		})
+	}
+	err = itr.Error()
+	itr.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range roots {
+		if len(r.hash) == 0 {
+			if err := m.w.set(v1NodeKey(r.version, rootNonce), []byte{}); err != nil {
+				return err
+			}
+			continue
+		}
+		nk, err := m.convert(r.hash, r.version)
+		if err != nil {
+			return err
+		}
+		// a version whose tree did not change points at the earlier root
+		if int64(binary.BigEndian.Uint64(nk)) != r.version {
+			if err := m.w.set(v1NodeKey(r.version, rootNonce), append([]byte{v1NodePrefix}, nk...)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteV1Nodes points the v1 nodes that reference legacy nodes at the
+// converted nodes.
+func (m *migrator) rewriteV1Nodes() error {
+	var keys [][]byte
+	itr, err := dbm.IteratePrefix(m.db, []byte{v1NodePrefix})
+	if err != nil {
+		return err
+	}
+	for ; itr.Valid(); itr.Next() {
+		key, value := itr.Key(), itr.Value()
+		if len(key) != 1+nodeKeySize || len(value) == 0 || isReference(value) {
+			continue
+		}
+		n, err := decodeNode(key[1:], value)
+		if err != nil {
+			itr.Close()
+			return err
+		}
+		if len(n.left) == hashSize || len(n.right) == hashSize {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+	}
+	err = itr.Error()
+	itr.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		n, err := getNode(m.db, key[1:])
+		if err != nil {
+			return err
+		}
+		if len(n.left) == hashSize {
+			if n.left, err = m.convert(n.left, 0); err != nil {
+				return err
+			}
+		}
+		if len(n.right) == hashSize {
+			if n.right, err = m.convert(n.right, 0); err != nil {
+				return err
+			}
+		}
+		if err := m.w.set(key, encodeNode(n)); err != nil {
+			return err
+		}
+		m.stats.Rewritten++
+	}
+	return nil
+}
+
+// convert writes the legacy node with the given hash and its subtree in the
+// v1 layout, unless they already are, and returns the node key of the node.
+// rootOf is the version the node is the root of, if any.
+func (m *migrator) convert(hash []byte, rootOf int64) ([]byte, error) {
+	if nk, ok := m.converted[string(hash)]; ok {
+		return nk, nil
+	}
+	nk, err := m.db.Get(migrationKey(hash))
+	if err != nil || nk != nil {
+		return nk, err
+	}
+
+	n, err := getNode(m.db, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isLeaf() {
+		if n.left, err = m.convert(n.left, 0); err != nil {
+			return nil, err
+		}
+		if n.right, err = m.convert(n.right, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	nonce := rootNonce
+	if n.version != rootOf {
+		if m.nonces[n.version] < rootNonce {
+			m.nonces[n.version] = rootNonce
+		}
+		m.nonces[n.version]++
+		nonce = m.nonces[n.version]
+		m.dirty[n.version] = true
+	}
+	nk = makeNodeKey(n.version, nonce)
+
+	// the node is written after its children and in the same batch as its
+	// node key, so a node with a node key has a converted subtree
+	m.converted[string(hash)] = nk
+	if err := m.w.batch.Set(migrationKey(hash), nk); err != nil {
+		return nil, err
+	}
+	if err := m.w.set(v1NodeKey(n.version, nonce), encodeNode(n)); err != nil {
+		return nil, err
+	}
+	m.stats.Converted++
+	return nk, nil
+}
+
+func migrationKey(hash []byte) []byte {
+	return append([]byte{migrationPrefix}, hash...)
+}
@@ -0,0 +1,148 @@
+package legacyiavl
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+// DeleteVersionsTo deletes the legacy versions up to and including toVersion
+// of a store: their roots, and the orphan records and nodes of the nodes no
+// later version uses. Call it before IAVL v1 loads the store, then let IAVL
+// delete the v1 versions up to toVersion.
+//
+// While legacy versions above toVersion remain, a node is dropped when the
+// orphan record says the last version using it is at most toVersion. Once
+// every legacy version goes, so do all orphan records, along with the nodes
+// of the latest legacy version that the first v1 version replaced, which
+// have no orphan record.
+func DeleteVersionsTo(ctx context.Context, db dbm.DB, toVersion int64) (Stats, error) {
+	var stats Stats
+	info, err := Inspect(db)
+	if err != nil || info.Versions == 0 || info.FirstVersion > toVersion {
+		return stats, err
+	}
+	if info.LatestVersion <= toVersion && info.V1LatestVersion <= toVersion {
+		return stats, fmt.Errorf("latest version %d is less than or equal to toVersion %d",
+			max(info.LatestVersion, info.V1LatestVersion), toVersion)
+	}
+
+	w := newWriter(ctx, db)
+	defer w.close()
+
+	if info.LatestVersion <= toVersion {
+		if stats.Nodes, err = deleteReplacedNodes(db, w, info.LatestVersion); err != nil {
+			return stats, err
+		}
+	}
+
+	// o<last version> sorts the orphan records by the last version using them
+	end := make([]byte, 9)
+	end[0] = orphanPrefix
+	binary.BigEndian.PutUint64(end[1:], uint64(toVersion+1))
+	itr, err := db.Iterator([]byte{orphanPrefix}, end)
+	if err != nil {
+		return stats, err
+	}
+	for ; itr.Valid(); itr.Next() {
+		key := itr.Key()
+		if len(key) != orphanKeySize {
+			continue
+		}
+		if err := w.delete(key); err != nil {
+			itr.Close()
+			return stats, err
+		}
+		if err := w.delete(legacyNodeKey(key[orphanKeySize-hashSize:])); err != nil {
+			itr.Close()
+			return stats, err
+		}
+		stats.Orphans++
+		stats.Nodes++
+	}
+	err = itr.Error()
+	itr.Close()
+	if err != nil {
+		return stats, err
+	}
+
+	roots, err := db.Iterator([]byte{rootPrefix}, legacyRootKey(toVersion+1))
+	if err != nil {
+		return stats, err
+	}
+	for ; roots.Valid(); roots.Next() {
+		if err := w.delete(roots.Key()); err != nil {
+			roots.Close()
+			return stats, err
+		}
+		stats.Roots++
+	}
+	err = roots.Error()
+	roots.Close()
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, w.flush()
+}
+
+// deleteReplacedNodes deletes the nodes of the latest legacy version that the
+// first v1 version no longer uses. The v1 nodes of that version point at the
+// legacy subtrees they kept by hash, so every legacy node outside of those
+// subtrees is gone from the v1 tree.
+func deleteReplacedNodes(db dbm.DB, w *writer, legacyLatest int64) (int, error) {
+	kept := make(map[string]bool)
+	rootKey, err := v1Root(db, legacyLatest+1)
+	if err != nil {
+		return 0, err
+	}
+	var collect func(ref []byte) error
+	collect = func(ref []byte) error {
+		if len(ref) == hashSize {
+			kept[string(ref)] = true
+			return nil
+		}
+		n, err := getNode(db, ref)
+		if err != nil || n.isLeaf() {
+			return err
+		}
+		if err := collect(n.left); err != nil {
+			return err
+		}
+		return collect(n.right)
+	}
+	if rootKey != nil {
+		if err := collect(rootKey); err != nil {
+			return 0, err
+		}
+	}
+
+	rootHash, err := legacyRoot(db, legacyLatest)
+	if err != nil || len(rootHash) == 0 {
+		return 0, err
+	}
+	var deleted int
+	var drop func(hash []byte) error
+	drop = func(hash []byte) error {
+		if kept[string(hash)] {
+			return nil
+		}
+		n, err := getNode(db, hash)
+		if err != nil {
+			return err
+		}
+		if !n.isLeaf() {
+			if err := drop(n.left); err != nil {
+				return err
+			}
+			if err := drop(n.right); err != nil {
+				return err
+			}
+		}
+		deleted++
+		return w.delete(legacyNodeKey(hash))
+	}
+	return deleted, drop(rootHash)
+}
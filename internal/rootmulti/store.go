@@ -5,6 +5,7 @@ import (
 	"io"
 	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -34,6 +35,10 @@ import (
 const (
 	latestVersionKey = "s/latest"
 	commitInfoKeyFmt = "s/%d" // s/<version>
+
+	// keys the pruning manager persists its pending heights under
+	pruneHeightsKey         = "s/pruneheights"
+	pruneSnapshotHeightsKey = "s/prunesnapshotheights"
 )
 
 const iavlDisablefastNodeDefault = false
@@ -74,6 +79,8 @@ type Store struct {
 	interBlockCache             types.MultiStorePersistentCache
 	listeners                   map[types.StoreKey][]types.WriteListener
 	commitHeader                cmtproto.Header
+	concurrency                 int
+	storeSizes                  map[string]int64
 }
 
 var (
@@ -133,6 +140,19 @@ func (rs *Store) SetIAVLFastNodeModuleWhitelist(modulesToWhitelist []string) {
 	}
 }
 
+// SetConcurrency limits how many stores are loaded or pruned at the same time.
+// Zero, the default, processes every store at once.
+func (rs *Store) SetConcurrency(concurrency int) {
+	rs.concurrency = concurrency
+}
+
+// SetStoreSizes sets the on-disk size of each store by name. Larger stores are
+// scheduled first when loading and pruning, which shortens the critical path
+// when concurrency is limited.
+func (rs *Store) SetStoreSizes(sizes map[string]int64) {
+	rs.storeSizes = sizes
+}
+
 // SetLazyLoading sets if the iavl store should be loaded lazily or not
 func (rs *Store) SetLazyLoading(lazyLoading bool) {
 	rs.lazyLoading = lazyLoading
@@ -255,10 +275,14 @@ func (rs *Store) loadVersion(ver int64, upgrades *types.StoreUpgrades) error {
 		resultChan := make(chan loadResult, len(storesKeys))
 		var wg sync.WaitGroup
 
+		rs.sortKeysBySize(storesKeys)
+		sem := rs.newStoreSemaphore(len(storesKeys))
 		for _, key := range storesKeys {
 			wg.Add(1)
+			sem <- struct{}{}
 			go func(k types.StoreKey) {
 				defer wg.Done()
+				defer func() { <-sem }()
 
 				storeParams := rs.storesParams[k]
 				commitID := rs.getCommitID(infos, k.Name())
@@ -356,6 +380,25 @@ func (rs *Store) loadVersion(ver int64, upgrades *types.StoreUpgrades) error {
 	return nil
 }
 
+// sortKeysBySize orders keys by the configured store sizes, largest first.
+func (rs *Store) sortKeysBySize(keys []types.StoreKey) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		return rs.storeSizes[keys[i].Name()] > rs.storeSizes[keys[j].Name()]
+	})
+}
+
+// newStoreSemaphore returns a channel bounding concurrent store work to the
+// configured concurrency, or to n when no limit is set.
+func (rs *Store) newStoreSemaphore(n int) chan struct{} {
+	if rs.concurrency > 0 && rs.concurrency < n {
+		n = rs.concurrency
+	}
+	if n < 1 {
+		n = 1
+	}
+	return make(chan struct{}, n)
+}
+
 func (rs *Store) getCommitID(infos map[string]types.StoreInfo, name string) types.CommitID {
 	info, ok := infos[name]
 	if !ok {
@@ -710,14 +753,22 @@ func (rs *Store) PruneStores(clearPruningManager bool, pruningHeights []int64) (
 		})
 	}
 
+	// Largest stores first, so they don't end up running alone at the end
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return rs.storeSizes[tasks[i].key.Name()] > rs.storeSizes[tasks[j].key.Name()]
+	})
+
 	// Prune each store in parallel using goroutines
 	errChan := make(chan error, len(tasks))
 	var wg sync.WaitGroup
 
+	sem := rs.newStoreSemaphore(len(tasks))
 	for _, task := range tasks {
 		wg.Add(1)
+		sem <- struct{}{}
 		go func(t pruneTask) {
 			defer wg.Done()
+			defer func() { <-sem }()
 			rs.logger.Info("pruning store", "key", t.key.Name())
 
 			err := t.store.(*iavl.Store).DeleteVersionsTo(pruneHeight)
@@ -1267,6 +1318,59 @@ func commitStores(version int64, storeMap map[types.StoreKey]types.CommitKVStore
 	}
 }
 
+// DeleteCommitInfosTo deletes the commit infos of the versions up to and
+// including version, whose store data pruning removed, and resets the heights
+// persisted by the pruning manager. The commit info of the latest version is
+// always kept. It returns the number of commit infos and pruning manager keys
+// deleted.
+func DeleteCommitInfosTo(db dbm.DB, version int64) (commitInfos, pruningKeys int, err error) {
+	latest := GetLatestVersion(db)
+
+	// digits sort before ':', so the range only holds s/<version> keys
+	itr, err := db.Iterator([]byte("s/0"), []byte("s/:"))
+	if err != nil {
+		return 0, 0, err
+	}
+	var keys [][]byte
+	for ; itr.Valid(); itr.Next() {
+		v, err := strconv.ParseInt(string(itr.Key()[len("s/"):]), 10, 64)
+		if err != nil || v > version || v >= latest {
+			continue
+		}
+		keys = append(keys, append([]byte(nil), itr.Key()...))
+	}
+	if err := itr.Error(); err != nil {
+		itr.Close()
+		return 0, 0, err
+	}
+	itr.Close()
+
+	batch := db.NewBatch()
+	defer batch.Close()
+	for _, key := range keys {
+		if err := batch.Delete(key); err != nil {
+			return 0, 0, err
+		}
+	}
+	for _, key := range []string{pruneHeightsKey, pruneSnapshotHeightsKey} {
+		ok, err := db.Has([]byte(key))
+		if err != nil {
+			return 0, 0, err
+		}
+		if !ok {
+			continue
+		}
+		if err := batch.Delete([]byte(key)); err != nil {
+			return 0, 0, err
+		}
+		pruningKeys++
+	}
+	if err := batch.WriteSync(); err != nil {
+		return 0, 0, err
+	}
+	return len(keys), pruningKeys, nil
+}
+
 func flushCommitInfo(batch dbm.Batch, version int64, cInfo *types.CommitInfo) {
 	bz, err := cInfo.Marshal()
 	if err != nil {
@@ -0,0 +1,47 @@
+package rootmulti
+
+import (
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cosmos/cosmos-sdk/store/types"
+)
+
+func TestDeleteCommitInfosTo(t *testing.T) {
+	db := dbm.NewMemDB()
+	store := NewStore(db, log.NewNopLogger())
+	key := types.NewKVStoreKey("bank")
+	store.MountStoreWithDB(key, types.StoreTypeIAVL, nil)
+	require.NoError(t, store.LoadLatestVersion())
+	for v := int64(1); v <= 12; v++ {
+		store.SetCommitHeader(cmtproto.Header{Height: v})
+		store.GetKVStore(key).Set([]byte("key"), []byte{byte(v)})
+		store.Commit()
+	}
+	require.NoError(t, db.Set([]byte(pruneHeightsKey), make([]byte, 8)))
+
+	require.NoError(t, store.PruneStores(false, []int64{10}))
+	commitInfos, pruningKeys, err := DeleteCommitInfosTo(db, 10)
+	require.NoError(t, err)
+	require.Equal(t, 10, commitInfos)
+	require.Equal(t, 1, pruningKeys)
+
+	for v := int64(1); v <= 12; v++ {
+		_, err := store.GetCommitInfo(v)
+		require.Equal(t, v > 10, err == nil, "version %d", v)
+	}
+	ok, err := db.Has([]byte(pruneHeightsKey))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// the latest version is kept even when asked to delete past it
+	commitInfos, _, err = DeleteCommitInfosTo(db, 20)
+	require.NoError(t, err)
+	require.Equal(t, 1, commitInfos)
+	_, err = store.GetCommitInfo(12)
+	require.NoError(t, err)
+}
@@ -0,0 +1,128 @@
+package rootmulti
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/cosmos/cosmos-sdk/store/types"
+)
+
+// storeKeyPrefix is the prefix of the data of every store, followed by the
+// store name and a slash.
+const storeKeyPrefix = "s/k:"
+
+// StorePrefix returns the prefix the data of the named store is kept under
+// in the application database.
+func StorePrefix(name string) []byte {
+	return []byte(storeKeyPrefix + name + "/")
+}
+
+// StoreNamesOnDisk returns the names of all stores with data in the
+// application database, in key order. Store prefixes are enumerated by seeking
+// past each store, so only one key per store is read.
+func StoreNamesOnDisk(db dbm.DB) ([]string, error) {
+	end := util.BytesPrefix([]byte(storeKeyPrefix)).Limit
+
+	var names []string
+	start := []byte(storeKeyPrefix)
+	for {
+		itr, err := db.Iterator(start, end)
+		if err != nil {
+			return nil, err
+		}
+		if !itr.Valid() {
+			err := itr.Error()
+			itr.Close()
+			return names, err
+		}
+		key := append([]byte(nil), itr.Key()...)
+		itr.Close()
+
+		rest := key[len(storeKeyPrefix):]
+		i := bytes.IndexByte(rest, '/')
+		if i < 0 {
+			// not a store key, skip just this key
+			start = append(key, 0)
+			continue
+		}
+		name := string(rest[:i])
+		names = append(names, name)
+		start = util.BytesPrefix(StorePrefix(name)).Limit
+	}
+}
+
+// LatestStoreNames returns the names of the stores recorded in the latest
+// commit info, which are the stores the node had mounted when it stopped.
+func LatestStoreNames(db dbm.DB) ([]string, error) {
+	latest := GetLatestVersion(db)
+	if latest <= 0 {
+		return nil, fmt.Errorf("the database has no valid heights, the latest height: %v", latest)
+	}
+
+	cInfo, err := NewStore(db, log.NewNopLogger()).GetCommitInfo(latest)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(cInfo.StoreInfos))
+	for _, info := range cInfo.StoreInfos {
+		names = append(names, info.Name)
+	}
+	return names, nil
+}
+
+// CommitInfoVersions returns the versions that have a commit info entry
+// (s/<version>) in the application database in ascending order. Digits sort
+// before ':', so the range skips the s/k:<store>/ data and the s/latest key.
+func CommitInfoVersions(db dbm.DB) ([]int64, error) {
+	itr, err := db.Iterator([]byte("s/0"), []byte("s/:"))
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+
+	var versions []int64
+	for ; itr.Valid(); itr.Next() {
+		version, err := strconv.ParseInt(string(itr.Key()[len("s/"):]), 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	if err := itr.Error(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+// LoadStoresAt mounts the named IAVL stores and loads the multistore at the
+// given version, or at the latest version when version is 0. Stores in
+// storeDBs are kept in databases of their own. Fast nodes are disabled so
+// loading never triggers an IAVL storage upgrade.
+func LoadStoresAt(db dbm.DB, logger log.Logger, storeDBs map[string]dbm.DB, version int64, names ...string) (*Store, error) {
+	if version == 0 {
+		version = GetLatestVersion(db)
+	}
+	if version <= 0 {
+		return nil, fmt.Errorf("the database has no valid heights, the latest height: %v", version)
+	}
+
+	rs := NewStore(db, logger)
+	rs.SetIAVLDisableFastNode(true)
+	for _, name := range names {
+		rs.MountStoreWithDB(types.NewKVStoreKey(name), types.StoreTypeIAVL, storeDBs[name])
+	}
+
+	if err := rs.LoadVersion(version); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
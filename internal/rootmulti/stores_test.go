@@ -0,0 +1,26 @@
+package rootmulti
+
+import (
+	"testing"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreNamesOnDisk(t *testing.T) {
+	db := dbm.NewMemDB()
+	for _, key := range []string{
+		"s/1", "s/latest",
+		"s/k:bank/a", "s/k:bank/b",
+		"s/k:bank2/a",
+		"s/k:bank-x/a",
+		"s/k:wasm/a", "s/k:wasm/b", "s/k:wasm/c",
+		"s/k:broken",
+	} {
+		require.NoError(t, db.Set([]byte(key), []byte("v")))
+	}
+
+	names, err := StoreNamesOnDisk(db)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bank-x", "bank", "bank2", "wasm"}, names)
+}
@@ -0,0 +1,208 @@
+// Package throttle limits the write and compaction IO cosmprund puts on a disk
+// shared with other services.
+package throttle
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dbm "github.com/cometbft/cometbft-db"
+)
+
+// Unit is what a Limiter budget is counted in.
+type Unit int
+
+const (
+	// Bytes budgets the size of the keys and values written or deleted.
+	Bytes Unit = iota
+	// Ops budgets the number of keys written or deleted.
+	Ops
+)
+
+var byteSuffixes = []struct {
+	suffix string
+	size   float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+// Limiter paces callers to a fixed rate. It keeps a virtual clock of when the
+// budget is next available, so callers never burst above the rate and large
+// requests simply wait longer. A nil Limiter never blocks.
+type Limiter struct {
+	mu   sync.Mutex
+	rate float64
+	unit Unit
+	next time.Time
+}
+
+// NewLimiter returns a Limiter allowing rate units per second.
+func NewLimiter(rate float64, unit Unit) *Limiter {
+	return &Limiter{rate: rate, unit: unit}
+}
+
+// ParseLimiter parses a rate such as "64MiB", "500MB" or "2000ops" per second.
+// An empty string or "0" returns a nil Limiter, meaning no limit.
+func ParseLimiter(s string) (*Limiter, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return nil, nil
+	}
+
+	if strings.HasSuffix(s, "ops") {
+		rate, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "ops")), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid io rate limit %q, expected e.g. 64MiB or 2000ops", s)
+		}
+		return NewLimiter(rate, Ops), nil
+	}
+
+	rate, err := ParseBytes(s)
+	if err != nil || rate <= 0 {
+		return nil, fmt.Errorf("invalid io rate limit %q, expected e.g. 64MiB or 2000ops", s)
+	}
+	return NewLimiter(float64(rate), Bytes), nil
+}
+
+// ParseBytes parses a size such as "512MiB", "8GB" or "1024".
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	mult, num := 1.0, s
+	for _, b := range byteSuffixes {
+		if strings.HasSuffix(s, b.suffix) {
+			mult, num = b.size, strings.TrimSuffix(s, b.suffix)
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 512MiB or 8GB", s)
+	}
+	return int64(n * mult), nil
+}
+
+// Unit returns what the limiter budget is counted in.
+func (l *Limiter) Unit() Unit {
+	return l.unit
+}
+
+// Wait blocks until n units of budget have been paid for.
+func (l *Limiter) Wait(n int64) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	end := l.next
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(end))
+}
+
+// String returns the limit in the same form ParseLimiter accepts.
+func (l *Limiter) String() string {
+	if l == nil {
+		return "unlimited"
+	}
+	if l.unit == Ops {
+		return fmt.Sprintf("%.0fops", l.rate)
+	}
+	return fmt.Sprintf("%.0fB", l.rate)
+}
+
+// PerSecond returns one second of budget, at least one unit. Work is best
+// paced in steps of about this size: larger steps burst above the rate
+// between long waits.
+func (l *Limiter) PerSecond() int64 {
+	return max(1, int64(l.rate))
+}
+
+// Cost returns the budget a single key write or delete takes.
+func (l *Limiter) Cost(key, value []byte) int64 {
+	if l.unit == Ops {
+		return 1
+	}
+	return int64(len(key) + len(value))
+}
+
+// DB wraps a dbm.DB so that writes and deletes, including those made through
+// batches, are paced by a Limiter. Reads are never throttled.
+type DB struct {
+	dbm.DB
+	limiter *Limiter
+}
+
+var _ dbm.DB = (*DB)(nil)
+
+// NewDB returns db wrapped with the limiter, or db itself when the limiter is nil.
+func NewDB(db dbm.DB, limiter *Limiter) dbm.DB {
+	if limiter == nil {
+		return db
+	}
+	return &DB{DB: db, limiter: limiter}
+}
+
+func (db *DB) Set(key, value []byte) error {
+	db.limiter.Wait(db.limiter.Cost(key, value))
+	return db.DB.Set(key, value)
+}
+
+func (db *DB) SetSync(key, value []byte) error {
+	db.limiter.Wait(db.limiter.Cost(key, value))
+	return db.DB.SetSync(key, value)
+}
+
+func (db *DB) Delete(key []byte) error {
+	db.limiter.Wait(db.limiter.Cost(key, nil))
+	return db.DB.Delete(key)
+}
+
+func (db *DB) DeleteSync(key []byte) error {
+	db.limiter.Wait(db.limiter.Cost(key, nil))
+	return db.DB.DeleteSync(key)
+}
+
+func (db *DB) NewBatch() dbm.Batch {
+	return &batch{Batch: db.DB.NewBatch(), limiter: db.limiter}
+}
+
+// batch accumulates the cost of its operations and pays it on write.
+type batch struct {
+	dbm.Batch
+	limiter *Limiter
+	cost    int64
+}
+
+func (b *batch) Set(key, value []byte) error {
+	b.cost += b.limiter.Cost(key, value)
+	return b.Batch.Set(key, value)
+}
+
+func (b *batch) Delete(key []byte) error {
+	b.cost += b.limiter.Cost(key, nil)
+	return b.Batch.Delete(key)
+}
+
+func (b *batch) Write() error {
+	b.limiter.Wait(b.cost)
+	b.cost = 0
+	return b.Batch.Write()
+}
+
+func (b *batch) WriteSync() error {
+	b.limiter.Wait(b.cost)
+	b.cost = 0
+	return b.Batch.WriteSync()
+}
@@ -0,0 +1,59 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimiter(t *testing.T) {
+	l, err := ParseLimiter("")
+	require.NoError(t, err)
+	require.Nil(t, l)
+
+	l, err = ParseLimiter("64MiB")
+	require.NoError(t, err)
+	require.Equal(t, Bytes, l.Unit())
+	require.Equal(t, float64(64<<20), l.rate)
+
+	l, err = ParseLimiter("500MB")
+	require.NoError(t, err)
+	require.Equal(t, float64(500e6), l.rate)
+
+	l, err = ParseLimiter("2000ops")
+	require.NoError(t, err)
+	require.Equal(t, Ops, l.Unit())
+	require.Equal(t, float64(2000), l.rate)
+	require.Equal(t, int64(2000), l.PerSecond())
+
+	l, err = ParseLimiter("1024")
+	require.NoError(t, err)
+	require.Equal(t, Bytes, l.Unit())
+
+	_, err = ParseLimiter("fast")
+	require.Error(t, err)
+	_, err = ParseLimiter("-1MB")
+	require.Error(t, err)
+}
+
+func TestThrottledBatch(t *testing.T) {
+	// 100 ops per second, 20 deletes must take at least ~200ms
+	db := NewDB(dbm.NewMemDB(), NewLimiter(100, Ops))
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Set([]byte{byte(i)}, []byte{1}))
+	}
+
+	batch := db.NewBatch()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, batch.Delete([]byte{byte(i)}))
+	}
+	start := time.Now()
+	require.NoError(t, batch.Write())
+	require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	ok, err := db.Has([]byte{1})
+	require.NoError(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,240 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cosmos/cosmos-sdk/types"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	authzkeeper "github.com/cosmos/cosmos-sdk/x/authz/keeper"
+	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
+	capabilitytypes "github.com/cosmos/cosmos-sdk/x/capability/types"
+	packetforwardtypes "github.com/cosmos/ibc-apps/middleware/packet-forward-middleware/v7/packetforward/types"
+	icqtypes "github.com/cosmos/ibc-apps/modules/async-icq/v7/types"
+	icahosttypes "github.com/cosmos/ibc-go/v7/modules/apps/27-interchain-accounts/host/types"
+	ibctransfertypes "github.com/cosmos/ibc-go/v7/modules/apps/transfer/types"
+	ibchost "github.com/cosmos/ibc-go/v7/modules/core/exported"
+
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	consensusparamtypes "github.com/cosmos/cosmos-sdk/x/consensus/types"
+	crisistypes "github.com/cosmos/cosmos-sdk/x/crisis/types"
+	distrtypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
+	evidencetypes "github.com/cosmos/cosmos-sdk/x/evidence/types"
+	govtypes "github.com/cosmos/cosmos-sdk/x/gov/types"
+	minttypes "github.com/cosmos/cosmos-sdk/x/mint/types"
+	paramstypes "github.com/cosmos/cosmos-sdk/x/params/types"
+	slashingtypes "github.com/cosmos/cosmos-sdk/x/slashing/types"
+	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	upgradetypes "github.com/cosmos/cosmos-sdk/x/upgrade/types"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/diskusage"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+)
+
+// AppResult is what pruning the application state did: the latest version of
+// the application state, and the version below which it was pruned, which is
+// zero when there was nothing to prune or the state is kept in memiavl.
+type AppResult struct {
+	LatestVersion int64
+	PruneVersion  int64
+}
+
+// addAppPhases adds the phase pruning the application state, in memiavl or
+// application.db, and with Options.Wasm the wasm directory cleanup, which
+// reads the pruned wasm store.
+func (p *Pruner) addAppPhases(o *orchestrator, version NodeVersion) *AppResult {
+	result := &AppResult{}
+	if hasMemIAVL(p.opts.DataDir) {
+		o.add("app", p.pruneMemIAVL)
+	} else {
+		o.add("app", func(ctx context.Context) error { return p.pruneAppState(ctx, version, result) })
+	}
+	if p.opts.Wasm {
+		o.add("wasm", p.pruneWasmDir, "app")
+	}
+	return result
+}
+
+func (p *Pruner) pruneAppState(ctx context.Context, version NodeVersion, result *AppResult) error {
+
+	// this has the potential to expand size, should just use state sync
+	// dbType := db.BackendType(backend)
+
+	dbDir := p.opts.DataDir
+
+	// Get BlockStore
+	appDB, err := p.openDB("application", dbDir, false)
+	if err != nil {
+		return err
+	}
+	defer appDB.Close()
+
+	//TODO: need to get all versions in the store, setting randomly is too slow
+	p.logger.Info("pruning application state")
+
+	// only mount keys from core sdk
+	// todo allow for other keys to be mounted
+	keys := types.NewKVStoreKeys(
+		authtypes.StoreKey, banktypes.StoreKey, authzkeeper.StoreKey, stakingtypes.StoreKey, distrtypes.StoreKey, slashingtypes.StoreKey, ibchost.StoreKey,
+		icahosttypes.StoreKey,
+		icqtypes.StoreKey,
+		evidencetypes.StoreKey, minttypes.StoreKey, govtypes.StoreKey, ibctransfertypes.StoreKey,
+		packetforwardtypes.StoreKey,
+		paramstypes.StoreKey, consensusparamtypes.StoreKey, capabilitytypes.StoreKey, crisistypes.StoreKey, upgradetypes.StoreKey,
+		// feegrant.StoreKey,
+	)
+
+	// the modules of SDK v0.50 apps differ from the ones above, so the stores
+	// the app last committed are mounted instead
+	if version == NodeVersion038 {
+		names, err := rootmulti.LatestStoreNames(appDB)
+		if err != nil {
+			return err
+		}
+		keys = types.NewKVStoreKeys(names...)
+	} else {
+		for _, name := range appProfiles[p.opts.App].stores {
+			keys[name] = types.NewKVStoreKey(name)
+		}
+	}
+	dbNames, err := p.profileStoreDBs()
+	if err != nil {
+		return err
+	}
+	for name := range dbNames {
+		if _, ok := keys[name]; !ok {
+			keys[name] = types.NewKVStoreKey(name)
+		}
+	}
+	if keys, err = p.committedStoreKeys(appDB, keys); err != nil {
+		return err
+	}
+
+	// stores kept in databases of their own are pruned and compacted with
+	// the rest of the application state
+	separateDBs, err := p.openStoreDBs(dbDir, keys, false)
+	if err != nil {
+		return err
+	}
+	defer closeStoreDBs(separateDBs)
+	storeDBs := make(map[string]db.DB, len(separateDBs))
+	for name, database := range separateDBs {
+		storeDBs[name] = throttle.NewDB(database, p.ioLimiter)
+		p.logger.Info("store is kept in a database of its own", "store", name, "db", dbNames[name])
+	}
+
+	// TODO: cleanup app state
+	appStore := rootmulti.NewStore(throttle.NewDB(appDB, p.ioLimiter), p.logger)
+	appStore.SetConcurrency(p.opts.Concurrency)
+
+	// Configure IAVL fast node
+	// Default: fast node disabled for faster pruning
+	// With KeepFastNodes: fast node enabled for queries, optionally only for whitelisted stores
+	appStore.SetIAVLDisableFastNode(!p.opts.KeepFastNodes)
+	if !p.opts.KeepFastNodes {
+		p.logger.Info("IAVL fast node disabled (faster pruning mode)")
+	} else {
+		appStore.SetIAVLFastNodeModuleWhitelist(p.opts.FastNodeWhitelist)
+		p.logger.Info("IAVL fast node enabled", "whitelist", p.opts.FastNodeWhitelist)
+	}
+
+	cacheSize := p.IAVLCacheSize(len(keys))
+	appStore.SetIAVLCacheSize(cacheSize)
+	p.logger.Info("IAVL cache size", "nodes_per_store", cacheSize, "stores", len(keys))
+
+	storeSizes := make(map[string]int64, len(keys))
+	for _, value := range keys {
+		appStore.MountStoreWithDB(value, storetypes.StoreTypeIAVL, storeDBs[value.Name()])
+
+		var size int64
+		if separateDB, ok := separateDBs[value.Name()]; ok {
+			size, err = diskusage.PrefixSize(separateDB, separateStorePrefix)
+		} else {
+			size, err = diskusage.PrefixSize(appDB, rootmulti.StorePrefix(value.Name()))
+		}
+		if err != nil {
+			return err
+		}
+		storeSizes[value.Name()] = size
+	}
+	appStore.SetStoreSizes(storeSizes)
+
+	latestHeight := rootmulti.GetLatestVersion(appDB)
+	// valid heights should be greater than 0.
+	if latestHeight <= 0 {
+		return fmt.Errorf("the database has no valid heights to prune, the latest height: %v", latestHeight)
+	}
+	result.LatestVersion = latestHeight
+
+	// var pruningHeights []int64
+	// for height := int64(1); height < latestHeight; height++ {
+	// 	if height < latestHeight-int64(versions) {
+	// 		pruningHeights = append(pruningHeights, height)
+	// 	}
+	// }
+
+	// Prune the last X versions
+	// This is the most efficient way to prune the application state
+	// as it only needs to delete the last X versions
+	pruneHeight := latestHeight - int64(p.opts.KeepVersions)
+	if pruneHeight <= 0 {
+		p.logger.Error("no heights to prune")
+		return nil
+	}
+	pruningHeights := []int64{pruneHeight}
+
+	// IAVL v1 does not prune stores written by IAVL v0.19/v0.20, their legacy
+	// versions are pruned before the stores are loaded
+	storeNames := make([]string, 0, len(keys))
+	for _, value := range keys {
+		storeNames = append(storeNames, value.Name())
+	}
+	if err := p.pruneLegacyStores(ctx, appDB, storeDBs, storeNames, pruneHeight); err != nil {
+		return err
+	}
+
+	err = appStore.LoadLatestVersion()
+	if err != nil {
+		return err
+	}
+	//pruningHeight := []int64{latestHeight - int64(versions)}
+
+	if err = appStore.PruneStores(false, pruningHeights); err != nil {
+		return err
+	}
+	result.PruneVersion = pruneHeight
+
+	// the commit infos of the pruned versions and any heights the pruning
+	// manager still had queued are stale now
+	commitInfos, pruningKeys, err := rootmulti.DeleteCommitInfosTo(appDB, pruneHeight)
+	if err != nil {
+		return err
+	}
+	p.logger.Info("removed stale metadata", "commit_infos", commitInfos, "pruning_heights", pruningKeys)
+	p.logger.Info("pruning application state complete")
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.logger.Info("compacting application state")
+	storePrefixes := make([][]byte, 0, len(keys))
+	for _, value := range keys {
+		if _, ok := separateDBs[value.Name()]; !ok {
+			storePrefixes = append(storePrefixes, rootmulti.StorePrefix(value.Name()))
+		}
+	}
+	if err := p.compactDB(ctx, "application", appDB, dbDir, storePrefixes); err != nil {
+		return err
+	}
+	for name, database := range separateDBs {
+		if err := p.compactDB(ctx, dbNames[name], database, dbDir, [][]byte{separateStorePrefix}); err != nil {
+			return err
+		}
+	}
+	p.logger.Info("compacting application state complete")
+
+	//create a new app store
+	return nil
+}
@@ -0,0 +1,231 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cometbft/cometbft/state"
+	tmstore "github.com/cometbft/cometbft/store"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+)
+
+// BlocksResult is what pruning the blocks found: the base and height of the
+// block store before pruning, and the height below which blocks, states and
+// indexed txs are pruned. PruneHeight is at most Base when there is nothing
+// to prune.
+type BlocksResult struct {
+	Base        int64
+	Height      int64
+	PruneHeight int64
+}
+
+// addBlockPhases adds the phases pruning the block store and, once the blocks
+// are gone, the state store, the tx index and the databases of the app
+// profile, which follow it. It refuses a state store of unknown version, as
+// pruning it decodes and saves consensus params in the format of a release.
+func (p *Pruner) addBlockPhases(o *orchestrator, version NodeVersion) (*BlocksResult, error) {
+	dbDir := p.opts.DataDir
+	if _, err := os.Stat(filepath.Join(dbDir, "state.db")); err == nil && version == NodeVersionUnknown {
+		return nil, fmt.Errorf("cannot tell the CometBFT version that wrote the state store, not pruning it")
+	}
+	base, height, pruneHeight, err := p.blockStoreHeights(dbDir)
+	if err != nil {
+		return nil, err
+	}
+	result := &BlocksResult{Base: base, Height: height, PruneHeight: pruneHeight}
+	if pruneHeight <= base {
+		p.logger.Info("no blocks to prune", "base", base, "target", pruneHeight)
+		return result, nil
+	}
+
+	o.add("blockstore", func(ctx context.Context) error { return p.pruneBlockStore(ctx, dbDir, pruneHeight) })
+	o.add("state", func(ctx context.Context) error { return p.pruneStateStore(ctx, dbDir, version, base, pruneHeight) }, "blockstore")
+	o.add("tx_index", func(ctx context.Context) error { return p.pruneTxIndexDB(ctx, dbDir, pruneHeight) }, "blockstore")
+	for _, extra := range appProfiles[p.opts.App].dbs {
+		extra := extra
+		o.add(extra.name, func(ctx context.Context) error { return p.pruneExtraDB(ctx, dbDir, extra, pruneHeight) }, "blockstore")
+	}
+	return result, nil
+}
+
+// blockStoreHeights returns the base and height of the block store and the
+// height below which blocks are pruned, based on the amount of blocks to keep.
+func (p *Pruner) blockStoreHeights(dbDir string) (base, height, pruneHeight int64, err error) {
+	blockStoreDB, err := p.openDB("blockstore", dbDir, true)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer blockStoreDB.Close()
+
+	blockStore := tmstore.NewBlockStore(blockStoreDB)
+	return blockStore.Base(), blockStore.Height(), blockStore.Height() - int64(p.opts.KeepBlocks), nil
+}
+
+// pruneBlockStore prunes the blocks below pruneHeight from the block store,
+// copying the retained blocks forward into a fresh database when that is the
+// cheaper way
+func (p *Pruner) pruneBlockStore(ctx context.Context, dbDir string, pruneHeight int64) error {
+	blockStoreDB, err := p.openDB("blockstore", dbDir, false)
+	if err != nil {
+		return err
+	}
+	defer blockStoreDB.Close()
+	blockStore := tmstore.NewBlockStore(throttle.NewDB(blockStoreDB, p.ioLimiter))
+
+	if p.useCopyForward(1 - pruneFraction(blockStore.Base(), blockStore.Height(), pruneHeight)) {
+		blockStoreDB.Close()
+		p.logger.Info("copying retained blocks into a new block store")
+		copied, err := p.copyForwardBlockStore(ctx, dbDir, pruneHeight)
+		if err != nil {
+			return err
+		}
+		p.logger.Info("copying block store complete", "copied", copied)
+		return nil
+	}
+
+	p.logger.Info("pruning block store")
+	pruned, err := blockStore.PruneBlocks(pruneHeight)
+	if err != nil {
+		return err
+	}
+	p.logger.Info("pruning block store complete", "pruned", pruned)
+
+	// CometBFT 0.38 also keeps the extended commit of every height
+	extended, err := pruneExtendedCommits(ctx, throttle.NewDB(blockStoreDB, p.ioLimiter), pruneHeight)
+	if err != nil {
+		return err
+	}
+	if extended > 0 {
+		p.logger.Info("pruned extended commits", "pruned", extended)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.logger.Info("compacting block store")
+	if err := p.compactDB(ctx, "blockstore", blockStoreDB, dbDir, prunedPrefixes["blockstore"]); err != nil {
+		return err
+	}
+	p.logger.Info("compacting block store complete")
+	return nil
+}
+
+// pruneStateStore prunes the states of the heights from base to pruneHeight,
+// copying the retained states forward into a fresh database when that is the
+// cheaper way. CometBFT 0.38 states are always copied: the 0.37 state store
+// saves the consensus params of the new base again in its own format, which
+// drops the ABCI params, while the copy keeps the bytes as they are.
+func (p *Pruner) pruneStateStore(ctx context.Context, dbDir string, version NodeVersion, base, pruneHeight int64) error {
+	stateDB, err := p.openDB("state", dbDir, false)
+	if err != nil {
+		return err
+	}
+	defer stateDB.Close()
+
+	stateStore := state.NewStore(throttle.NewDB(stateDB, p.ioLimiter), state.StoreOptions{
+		DiscardABCIResponses: true,
+	})
+
+	latest, err := stateStore.Load()
+	if err != nil {
+		return err
+	}
+	copyForward := p.useCopyForward(1 - pruneFraction(base, latest.LastBlockHeight, pruneHeight))
+	if !copyForward && version == NodeVersion038 {
+		p.logger.Info("copying the state store forward, deleting states would drop the ABCI params of CometBFT 0.38")
+		copyForward = true
+	}
+	if copyForward {
+		stateDB.Close()
+		p.logger.Info("copying retained states into a new state store")
+		retained, err := p.copyForwardState(ctx, dbDir, pruneHeight)
+		if err != nil {
+			return err
+		}
+		p.logger.Info("copying state store complete", "retained", retained)
+		return nil
+	}
+
+	p.logger.Info("pruning state store")
+	if err := stateStore.PruneStates(base, pruneHeight); err != nil {
+		return err
+	}
+	p.logger.Info("pruning state store complete")
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.logger.Info("compacting state store")
+	if err := p.compactDB(ctx, "state", stateDB, dbDir, prunedPrefixes["state"]); err != nil {
+		return err
+	}
+	p.logger.Info("compacting state store complete")
+	return nil
+}
+
+// pruneTxIndexDB prunes the txs and block events below pruneHeight from the
+// tx index, if the node has one
+func (p *Pruner) pruneTxIndexDB(ctx context.Context, dbDir string, pruneHeight int64) error {
+	if _, err := os.Stat(filepath.Join(dbDir, "tx_index.db")); os.IsNotExist(err) {
+		p.logger.Info("no tx index to prune")
+		return nil
+	}
+
+	txIndexDB, err := p.openDB("tx_index", dbDir, false)
+	if err != nil {
+		return err
+	}
+	defer txIndexDB.Close()
+
+	p.logger.Info("pruning tx index")
+	deleted, err := pruneTxIndex(ctx, throttle.NewDB(txIndexDB, p.ioLimiter), pruneHeight)
+	if err != nil {
+		return err
+	}
+	p.logger.Info("pruning tx index complete", "deleted", deleted)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.logger.Info("compacting tx index")
+	if err := p.compactDB(ctx, "tx_index", txIndexDB, dbDir, prunedPrefixes["tx_index"]); err != nil {
+		return err
+	}
+	p.logger.Info("compacting tx index complete")
+	return nil
+}
+
+// pruneExtraDB prunes and compacts a database an app keeps next to
+// application.db, if the data directory has it.
+func (p *Pruner) pruneExtraDB(ctx context.Context, dbDir string, extra extraDB, pruneHeight int64) error {
+	if _, err := os.Stat(filepath.Join(dbDir, extra.name+".db")); os.IsNotExist(err) {
+		p.logger.Info("no db to prune", "db", extra.name)
+		return nil
+	}
+
+	database, err := p.openDB(extra.name, dbDir, false)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	p.logger.Info("pruning db", "db", extra.name)
+	deleted, err := extra.prune(ctx, throttle.NewDB(database, p.ioLimiter), pruneHeight)
+	if err != nil {
+		return err
+	}
+	p.logger.Info("pruning db complete", "db", extra.name, "deleted", deleted)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.logger.Info("compacting db", "db", extra.name)
+	if err := p.compactDB(ctx, extra.name, database, dbDir, prunedPrefixes[extra.name]); err != nil {
+		return err
+	}
+	p.logger.Info("compacting db complete", "db", extra.name)
+	return nil
+}
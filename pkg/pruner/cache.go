@@ -0,0 +1,73 @@
+package pruner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/store/iavl"
+)
+
+// iavlNodeSizeEstimate is a conservative estimate of the memory an IAVL node
+// takes in the node cache, including its key, value and bookkeeping.
+const iavlNodeSizeEstimate = 1024
+
+// IAVLCacheSize returns the IAVL node cache size to use for each of
+// numStores stores. An explicit Options.IAVLCacheSize wins, otherwise the
+// cache is sized so all stores fit Options.MemoryBudget, which defaults to
+// half of the memory currently available.
+func (p *Pruner) IAVLCacheSize(numStores int) int {
+	if p.opts.IAVLCacheSize > 0 {
+		return p.opts.IAVLCacheSize
+	}
+
+	budget := p.opts.MemoryBudget
+	if budget <= 0 {
+		available, err := availableMemory()
+		if err != nil {
+			p.logger.Info("could not read available memory, using the default IAVL cache size", "err", err)
+			return iavl.DefaultIAVLCacheSize
+		}
+		budget = available / 2
+	}
+
+	if numStores < 1 {
+		numStores = 1
+	}
+	size := budget / int64(numStores) / iavlNodeSizeEstimate
+	if size > iavl.DefaultIAVLCacheSize {
+		size = iavl.DefaultIAVLCacheSize
+	}
+	if size < 1 {
+		size = 1
+	}
+	return int(size)
+}
+
+// availableMemory returns MemAvailable from /proc/meminfo in bytes.
+func availableMemory() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("MemAvailable not found in /proc/meminfo")
+}
@@ -0,0 +1,242 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/diskusage"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+)
+
+// prunedPrefixes are the key prefixes pruning deletes from in each database.
+// They are compacted first, as that is where the space is reclaimed.
+var prunedPrefixes = map[string][][]byte{
+	"blockstore": {[]byte("H:"), []byte("P:"), []byte("C:"), []byte("SC:"), []byte("BH:"), extendedCommitPrefix},
+	"state":      {[]byte("abciResponsesKey:"), []byte("validatorsKey:"), []byte("consensusParamsKey:")},
+	"tx_index":   {txHeightPrefix, blockEventsPrefix},
+	"evmindexer": {{evmTxHashPrefix}, {evmTxIndexPrefix}},
+}
+
+// Compact compacts the named databases of the data directory in key range
+// chunks without pruning them, and returns the bytes reclaimed by database.
+// Missing databases are skipped. Compaction stops once the free space drops
+// below Options.MinFreeSpace.
+func (p *Pruner) Compact(ctx context.Context, names []string) (map[string]int64, error) {
+	dbDir := p.opts.DataDir
+	reclaimed := make(map[string]int64, len(names))
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dbDir, name+".db")); os.IsNotExist(err) {
+			p.logger.Info("skipping missing db", "db", name)
+			continue
+		}
+
+		database, err := p.openDB(name, dbDir, false)
+		if err != nil {
+			return reclaimed, err
+		}
+
+		prefixes := prunedPrefixes[name]
+		if name == "application" {
+			if prefixes, err = appStorePrefixes(database); err != nil {
+				database.Close()
+				return reclaimed, err
+			}
+		}
+
+		p.logger.Info("compacting", "db", name)
+		reclaimed[name], err = p.compactChunks(ctx, name, database, dbDir, compactionChunks(prefixes))
+		database.Close()
+		if err != nil {
+			return reclaimed, err
+		}
+		p.logger.Info("compacting complete", "db", name, "reclaimed", reclaimed[name])
+	}
+	return reclaimed, nil
+}
+
+// compactDB compacts the whole database in one go. With an IO rate limit set,
+// or when the preflight found too little space for a whole compaction, it is
+// compacted in chunks instead, stopping when the free space of dir drops
+// below Options.MinFreeSpace.
+func (p *Pruner) compactDB(ctx context.Context, name string, database *db.GoLevelDB, dir string, prefixes [][]byte) error {
+	if p.ioLimiter == nil && !p.chunkedCompaction {
+		return database.Compact(nil, nil)
+	}
+	_, err := p.compactChunks(ctx, name, database, dir, compactionChunks(prefixes))
+	return err
+}
+
+// compactPrefix compacts the key range under prefix of the named database and
+// returns the number of bytes reclaimed, with the IO budget and free space
+// checks of compactChunks.
+func (p *Pruner) compactPrefix(ctx context.Context, name string, database *db.GoLevelDB, dir string, prefix []byte) (int64, error) {
+	return p.compactChunks(ctx, name, database, dir, []util.Range{*util.BytesPrefix(prefix)})
+}
+
+// compactChunks compacts the key ranges in chunks one at a time and returns
+// the number of bytes reclaimed. Each chunk is compacted with compactPaced.
+// When dir is set, compaction stops with an error once its free space drops below
+// Options.MinFreeSpace. It also stops between chunks when ctx is cancelled.
+func (p *Pruner) compactChunks(ctx context.Context, name string, database *db.GoLevelDB, dir string, chunks []util.Range) (int64, error) {
+	minFree := p.opts.MinFreeSpace
+	var reclaimed int64
+	for _, r := range chunks {
+		if err := ctx.Err(); err != nil {
+			return reclaimed, err
+		}
+		sizes, err := database.DB().SizeOf([]util.Range{r})
+		if err != nil {
+			return reclaimed, err
+		}
+		before := sizes.Sum()
+		if before == 0 {
+			continue
+		}
+
+		if dir != "" {
+			free, err := diskusage.Free(dir)
+			if err != nil {
+				return reclaimed, err
+			}
+			if free < minFree {
+				return reclaimed, fmt.Errorf("stopped compacting %s: %d bytes free, below the minimum of %d", name, free, minFree)
+			}
+		}
+
+		if err := p.compactPaced(ctx, database, r); err != nil {
+			return reclaimed, err
+		}
+
+		sizes, err = database.DB().SizeOf([]util.Range{r})
+		if err != nil {
+			return reclaimed, err
+		}
+		after := sizes.Sum()
+		reclaimed += before - after
+		p.logger.Info("compacted chunk", "db", name, "start", fmt.Sprintf("%q", r.Start), "end", fmt.Sprintf("%q", r.Limit),
+			"before", before, "after", after, "reclaimed", before-after)
+	}
+	return reclaimed, nil
+}
+
+// compactPaced compacts the key range r. With an IO rate limit set, it is
+// compacted in steps of about one second of budget, counted over the live
+// keys and values each step rewrites, and waits for the budget of each step
+// before compacting it. It stops between steps when ctx is cancelled.
+func (p *Pruner) compactPaced(ctx context.Context, database *db.GoLevelDB, r util.Range) error {
+	if p.ioLimiter == nil {
+		return database.Compact(r.Start, r.Limit)
+	}
+	steps, err := compactionSteps(database, r, p.ioLimiter)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.ioLimiter.Wait(step.cost)
+		if err := database.Compact(step.Start, step.Limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactionStep is a key range compacted at once and the budget it takes.
+type compactionStep struct {
+	util.Range
+	cost int64
+}
+
+// compactionSteps splits r into consecutive ranges whose live keys and values
+// cost about one second of the limiter's budget each. The split points are
+// found before compacting, so no iterator pins the tables being replaced.
+func compactionSteps(database *db.GoLevelDB, r util.Range, limiter *throttle.Limiter) ([]compactionStep, error) {
+	itr := database.DB().NewIterator(&r, &opt.ReadOptions{DontFillCache: true})
+	defer itr.Release()
+
+	perStep := limiter.PerSecond()
+	var steps []compactionStep
+	step := compactionStep{Range: util.Range{Start: r.Start}}
+	for itr.Next() {
+		if step.cost += limiter.Cost(itr.Key(), itr.Value()); step.cost < perStep {
+			continue
+		}
+		// the step ends right after this key
+		step.Limit = append(append([]byte(nil), itr.Key()...), 0x00)
+		steps = append(steps, step)
+		step = compactionStep{Range: util.Range{Start: step.Limit}}
+	}
+	if err := itr.Error(); err != nil {
+		return nil, err
+	}
+	// the rest of the range, which may hold only deleted keys, costs at least
+	// one unit so compacting it is paced too
+	step.Limit, step.cost = r.Limit, max(step.cost, 1)
+	return append(steps, step), nil
+}
+
+// compactionChunks returns the key ranges to compact: one range per prefix,
+// in the given order, followed by the rest of the key space split at every
+// leading byte.
+func compactionChunks(prefixes [][]byte) []util.Range {
+	chunks := make([]util.Range, 0, len(prefixes)+256)
+	for _, p := range prefixes {
+		chunks = append(chunks, *util.BytesPrefix(p))
+	}
+
+	sorted := append([]util.Range(nil), chunks...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].Start, sorted[j].Start) < 0 })
+
+	// split points of the remaining key space: every leading byte and the
+	// boundaries of the prefix ranges
+	var start []byte
+	addGap := func(limit []byte) {
+		for b := 1; b <= 0xff; b++ {
+			point := []byte{byte(b)}
+			if bytes.Compare(point, start) > 0 && (limit == nil || bytes.Compare(point, limit) < 0) {
+				chunks = append(chunks, util.Range{Start: start, Limit: point})
+				start = point
+			}
+		}
+		if limit == nil || bytes.Compare(start, limit) < 0 {
+			chunks = append(chunks, util.Range{Start: start, Limit: limit})
+		}
+	}
+	for _, r := range sorted {
+		if bytes.Compare(start, r.Start) < 0 {
+			addGap(r.Start)
+		}
+		if r.Limit == nil {
+			return chunks
+		}
+		if bytes.Compare(start, r.Limit) < 0 {
+			start = r.Limit
+		}
+	}
+	addGap(nil)
+	return chunks
+}
+
+// appStorePrefixes returns the key prefix of every store in the latest commit info.
+func appStorePrefixes(appDB db.DB) ([][]byte, error) {
+	names, err := rootmulti.LatestStoreNames(appDB)
+	if err != nil {
+		return nil, err
+	}
+	prefixes := make([][]byte, 0, len(names))
+	for _, name := range names {
+		prefixes = append(prefixes, rootmulti.StorePrefix(name))
+	}
+	return prefixes, nil
+}
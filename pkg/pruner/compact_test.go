@@ -0,0 +1,70 @@
+package pruner
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+)
+
+func TestCompactionChunks(t *testing.T) {
+	chunks := compactionChunks([][]byte{[]byte("s/k:bank/"), []byte("s/k:acc/")})
+
+	// pruned prefixes come first, in the given order
+	require.Equal(t, []byte("s/k:bank/"), chunks[0].Start)
+	require.Equal(t, []byte("s/k:bank0"), chunks[0].Limit)
+	require.Equal(t, []byte("s/k:acc/"), chunks[1].Start)
+	require.Equal(t, []byte("s/k:acc0"), chunks[1].Limit)
+
+	// together the chunks cover the whole key space without overlapping
+	sort.Slice(chunks, func(i, j int) bool { return bytes.Compare(chunks[i].Start, chunks[j].Start) < 0 })
+	require.Nil(t, chunks[0].Start)
+	require.Nil(t, chunks[len(chunks)-1].Limit)
+	for i := 1; i < len(chunks); i++ {
+		require.Equal(t, chunks[i-1].Limit, chunks[i].Start, "chunk %d", i)
+	}
+}
+
+func TestCompactionChunksNoPrefixes(t *testing.T) {
+	chunks := compactionChunks(nil)
+	require.Len(t, chunks, 256)
+	require.Nil(t, chunks[0].Start)
+	require.Equal(t, []byte{0x01}, chunks[0].Limit)
+	require.Equal(t, []byte{0xff}, chunks[255].Start)
+	require.Nil(t, chunks[255].Limit)
+}
+
+func TestCompactionSteps(t *testing.T) {
+	database, err := db.NewGoLevelDB("blockstore", t.TempDir())
+	require.NoError(t, err)
+	defer database.Close()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, database.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("0123456789")))
+	}
+	r := *util.BytesPrefix([]byte("k"))
+
+	// 14 bytes a key, steps of 100 bytes end after every 8th key
+	steps, err := compactionSteps(database, r, throttle.NewLimiter(100, throttle.Bytes))
+	require.NoError(t, err)
+	require.Len(t, steps, 13)
+	require.Equal(t, r.Start, steps[0].Start)
+	for i, step := range steps[:12] {
+		require.Equal(t, int64(112), step.cost)
+		require.Equal(t, []byte(fmt.Sprintf("k%03d\x00", 8*i+7)), step.Limit)
+		require.Equal(t, step.Limit, steps[i+1].Start)
+	}
+	require.Equal(t, r.Limit, steps[12].Limit)
+	require.Equal(t, int64(4*14), steps[12].cost)
+
+	// one op a key, and the empty rest of the range still costs one
+	steps, err = compactionSteps(database, r, throttle.NewLimiter(50, throttle.Ops))
+	require.NoError(t, err)
+	require.Len(t, steps, 3)
+	require.Equal(t, []int64{50, 50, 1}, []int64{steps[0].cost, steps[1].cost, steps[2].cost})
+}
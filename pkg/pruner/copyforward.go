@@ -0,0 +1,467 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	db "github.com/cometbft/cometbft-db"
+	cmtstate "github.com/cometbft/cometbft/proto/tendermint/state"
+	cmtstore "github.com/cometbft/cometbft/proto/tendermint/store"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	"github.com/cometbft/cometbft/state"
+	tmstore "github.com/cometbft/cometbft/store"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+)
+
+const (
+	// copyForwardBatchHeights is the number of heights copied per batch.
+	copyForwardBatchHeights = 1000
+	// copyBatchKeys is the number of keys copied per batch by copyRange.
+	copyBatchKeys = 10000
+	// valSetCheckpointInterval is the interval at which the state store saves
+	// the full validator set even when it did not change.
+	valSetCheckpointInterval = 100000
+)
+
+// useCopyForward reports whether a height indexed database retaining the given fraction of
+// its heights is pruned by copying the retained heights into a fresh database
+// rather than deleting the pruned ones. Deleting writes a tombstone per pruned
+// key and compaction then rewrites the retained data anyway, so copying wins
+// when little is retained.
+func (p *Pruner) useCopyForward(retained float64) bool {
+	switch p.opts.Strategy {
+	case StrategyCopy:
+		return true
+	case StrategyDelete:
+		return false
+	}
+	return retained <= p.opts.CopyThreshold
+}
+
+// copyForwardBlockStore prunes the block store below pruneHeight by copying the
+// retained blocks, and every key outside the block prefixes, into a fresh
+// database that then replaces blockstore.db. It returns the number of heights
+// copied.
+func (p *Pruner) copyForwardBlockStore(ctx context.Context, dbDir string, pruneHeight int64) (int64, error) {
+	src, err := p.openDB("blockstore", dbDir, true)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	blockStore := tmstore.NewBlockStore(src)
+	height := blockStore.Height()
+
+	dst, err := p.openNewDB("blockstore", dbDir)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	out := throttle.NewDB(dst, p.ioLimiter)
+
+	// metadata and anything else pruning does not touch is copied as is,
+	// seeking past the block prefixes rather than reading the pruned blocks
+	for _, gap := range prefixGaps(prunedPrefixes["blockstore"]) {
+		if err := copyRange(ctx, src, out, gap, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	var copied int64
+	batch := out.NewBatch()
+	defer func() { batch.Close() }()
+	for h := pruneHeight; h <= height; h++ {
+		meta := blockStore.LoadBlockMeta(h)
+		if meta == nil {
+			return copied, fmt.Errorf("block meta at retained height %d is missing", h)
+		}
+
+		keys := [][]byte{
+			[]byte(fmt.Sprintf("H:%v", h)),
+			[]byte(fmt.Sprintf("C:%v", h)),
+			[]byte(fmt.Sprintf("SC:%v", h)),
+			[]byte(fmt.Sprintf("EC:%v", h)),
+			// HexBytes formats as upper case, the key is lower case
+			[]byte(fmt.Sprintf("BH:%x", []byte(meta.BlockID.Hash))),
+		}
+		for part := 0; part < int(meta.BlockID.PartSetHeader.Total); part++ {
+			keys = append(keys, []byte(fmt.Sprintf("P:%v:%v", h, part)))
+		}
+		for _, key := range keys {
+			value, err := src.Get(key)
+			if err != nil {
+				return copied, err
+			}
+			if value == nil {
+				continue
+			}
+			if err := batch.Set(key, value); err != nil {
+				return copied, err
+			}
+		}
+		copied++
+
+		if copied%copyForwardBatchHeights == 0 {
+			if err := batch.Write(); err != nil {
+				return copied, err
+			}
+			batch.Close()
+			batch = out.NewBatch()
+			if err := ctx.Err(); err != nil {
+				return copied, err
+			}
+			p.logger.Info("copying block store", "height", h, "target", height)
+		}
+	}
+	if err := batch.WriteSync(); err != nil {
+		return copied, err
+	}
+	tmstore.SaveBlockStoreState(&cmtstore.BlockStoreState{Base: pruneHeight, Height: height}, dst)
+
+	// make sure the copy is usable before it replaces the original
+	copyStore := tmstore.NewBlockStore(dst)
+	if copyStore.Base() != pruneHeight || copyStore.Height() != height {
+		return copied, fmt.Errorf("copied block store has base %d and height %d, expected %d and %d",
+			copyStore.Base(), copyStore.Height(), pruneHeight, height)
+	}
+	for _, h := range []int64{pruneHeight, height} {
+		block := copyStore.LoadBlock(h)
+		if block == nil || copyStore.LoadBlockByHash(block.Hash()) == nil {
+			return copied, fmt.Errorf("copied block store is missing the block at height %d", h)
+		}
+	}
+
+	src.Close()
+	dst.Close()
+	return copied, p.swapDB(dbDir, "blockstore")
+}
+
+// copyForwardState prunes the state store below pruneHeight by copying the
+// latest state, the ABCI responses, validator sets and consensus params of the
+// retained heights, and the earlier validator set and params checkpoints these
+// refer to, into a fresh database that then replaces state.db. The validators
+// and params of every retained height are checked against the original before
+// the swap. It returns the number of heights retained.
+func (p *Pruner) copyForwardState(ctx context.Context, dbDir string, pruneHeight int64) (int64, error) {
+	src, err := p.openDB("state", dbDir, true)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	srcStore := state.NewStore(src, state.StoreOptions{})
+	latest, err := srcStore.Load()
+	if err != nil {
+		return 0, err
+	}
+	if latest.IsEmpty() {
+		return 0, fmt.Errorf("state store has no state")
+	}
+
+	dst, err := p.openNewDB("state", dbDir)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	out := throttle.NewDB(dst, p.ioLimiter)
+
+	// the latest state, metadata and the keys of retained heights are copied as is
+	if err := copyKeys(ctx, src, out, func(key []byte) bool {
+		for _, prefix := range prunedPrefixes["state"] {
+			if bytes.HasPrefix(key, prefix) {
+				h, err := strconv.ParseInt(string(key[len(prefix):]), 10, 64)
+				return err != nil || h >= pruneHeight
+			}
+		}
+		return true
+	}); err != nil {
+		return 0, err
+	}
+
+	// retained heights without a full validator set or params point back at
+	// the height they were last saved at, which may be pruned
+	refs, err := stateCheckpoints(dst, pruneHeight)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range refs {
+		value, err := src.Get(key)
+		if err != nil {
+			return 0, err
+		}
+		if value == nil {
+			return 0, fmt.Errorf("state checkpoint %s is missing", key)
+		}
+		if err := out.SetSync(key, value); err != nil {
+			return 0, err
+		}
+	}
+	p.logger.Info("copied state checkpoints", "checkpoints", len(refs))
+
+	// make sure the copy is usable before it replaces the original
+	copyStore := state.NewStore(dst, state.StoreOptions{})
+	copied, err := copyStore.Load()
+	if err != nil {
+		return 0, err
+	}
+	if copied.LastBlockHeight != latest.LastBlockHeight {
+		return 0, fmt.Errorf("copied state is at height %d, expected %d", copied.LastBlockHeight, latest.LastBlockHeight)
+	}
+	for h := pruneHeight; h <= latest.LastBlockHeight+1; h++ {
+		if (h-pruneHeight)%copyForwardBatchHeights == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		if err := verifyStateHeight(srcStore, copyStore, h); err != nil {
+			return 0, err
+		}
+	}
+
+	src.Close()
+	dst.Close()
+	return latest.LastBlockHeight - pruneHeight + 1, p.swapDB(dbDir, "state")
+}
+
+// stateCheckpoints returns the validators and consensus params keys below
+// pruneHeight that the retained heights of the state store refer to.
+func stateCheckpoints(stateDB db.DB, pruneHeight int64) ([][]byte, error) {
+	seen := make(map[string]bool)
+	var refs [][]byte
+	ref := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			refs = append(refs, []byte(key))
+		}
+	}
+
+	err := iterateHeights(stateDB, "validatorsKey:", func(h int64, value []byte) error {
+		var info cmtstate.ValidatorsInfo
+		if err := info.Unmarshal(value); err != nil {
+			return fmt.Errorf("validators at height %d: %w", h, err)
+		}
+		if info.ValidatorSet != nil {
+			return nil
+		}
+		stored := h - h%valSetCheckpointInterval
+		if info.LastHeightChanged > stored {
+			stored = info.LastHeightChanged
+		}
+		if stored < pruneHeight {
+			ref(fmt.Sprintf("validatorsKey:%v", stored))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = iterateHeights(stateDB, "consensusParamsKey:", func(h int64, value []byte) error {
+		var info cmtstate.ConsensusParamsInfo
+		if err := info.Unmarshal(value); err != nil {
+			return fmt.Errorf("consensus params at height %d: %w", h, err)
+		}
+		if info.ConsensusParams.Equal(&cmtproto.ConsensusParams{}) && info.LastHeightChanged < pruneHeight {
+			ref(fmt.Sprintf("consensusParamsKey:%v", info.LastHeightChanged))
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// iterateHeights calls fn with the height and value of every key under prefix.
+func iterateHeights(database db.DB, prefix string, fn func(h int64, value []byte) error) error {
+	itr, err := db.IteratePrefix(database, []byte(prefix))
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+
+	for ; itr.Valid(); itr.Next() {
+		h, err := strconv.ParseInt(string(itr.Key()[len(prefix):]), 10, 64)
+		if err != nil {
+			continue
+		}
+		if err := fn(h, itr.Value()); err != nil {
+			return err
+		}
+	}
+	return itr.Error()
+}
+
+// verifyStateHeight checks that the copied state store returns the same
+// validators and consensus params at height as the original.
+func verifyStateHeight(original, copied state.Store, height int64) error {
+	want, err := original.LoadValidators(height)
+	if err != nil {
+		return err
+	}
+	got, err := copied.LoadValidators(height)
+	if err != nil {
+		return fmt.Errorf("copied state: %w", err)
+	}
+	if !bytes.Equal(got.Hash(), want.Hash()) || !bytes.Equal(got.GetProposer().Address, want.GetProposer().Address) {
+		return fmt.Errorf("copied state has different validators at height %d", height)
+	}
+
+	wantParams, err := original.LoadConsensusParams(height)
+	if err != nil {
+		return err
+	}
+	gotParams, err := copied.LoadConsensusParams(height)
+	if err != nil {
+		return fmt.Errorf("copied state: %w", err)
+	}
+	if !bytes.Equal(gotParams.Hash(), wantParams.Hash()) {
+		return fmt.Errorf("copied state has different consensus params at height %d", height)
+	}
+	return nil
+}
+
+// copyKeys copies every key of src for which keep returns true to dst. It
+// stops when ctx is cancelled, checking every copyBatchKeys keys read.
+func copyKeys(ctx context.Context, src, dst db.DB, keep func(key []byte) bool) error {
+	return copyRange(ctx, src, dst, util.Range{}, keep)
+}
+
+// copyRange copies the keys of src in r for which keep, if set, returns true
+// to dst. It stops when ctx is cancelled, checking every copyBatchKeys keys
+// read, whether they are kept or not.
+func copyRange(ctx context.Context, src, dst db.DB, r util.Range, keep func(key []byte) bool) error {
+	itr, err := src.Iterator(r.Start, r.Limit)
+	if err != nil {
+		return err
+	}
+	defer itr.Close()
+
+	batch := dst.NewBatch()
+	defer func() { batch.Close() }()
+	var read, pending int
+	for ; itr.Valid(); itr.Next() {
+		if read++; read%copyBatchKeys == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if keep != nil && !keep(itr.Key()) {
+			continue
+		}
+		if err := batch.Set(itr.Key(), itr.Value()); err != nil {
+			return err
+		}
+		if pending++; pending == copyBatchKeys {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Close()
+			batch, pending = dst.NewBatch(), 0
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return err
+	}
+	return batch.WriteSync()
+}
+
+// prefixGaps returns the key ranges outside of every prefix, in key order.
+func prefixGaps(prefixes [][]byte) []util.Range {
+	ranges := make([]util.Range, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		ranges = append(ranges, *util.BytesPrefix(prefix))
+	}
+	sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].Start, ranges[j].Start) < 0 })
+
+	var (
+		gaps  []util.Range
+		start []byte
+	)
+	for _, r := range ranges {
+		if bytes.Compare(start, r.Start) < 0 {
+			gaps = append(gaps, util.Range{Start: start, Limit: r.Start})
+		}
+		if r.Limit == nil {
+			return gaps
+		}
+		if bytes.Compare(start, r.Limit) < 0 {
+			start = r.Limit
+		}
+	}
+	return append(gaps, util.Range{Start: start})
+}
+
+// openNewDB creates an empty <name>.new.db in dir with the options of the
+// named database, removing any leftover of an interrupted copy.
+func (p *Pruner) openNewDB(name, dir string) (*db.GoLevelDB, error) {
+	if err := os.RemoveAll(filepath.Join(dir, name+".new.db")); err != nil {
+		return nil, err
+	}
+	o, err := p.levelDBOptions(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewGoLevelDBWithOpts(name+".new", dir, o)
+}
+
+// swapDB replaces <name>.db with the copy in <name>.new.db. The original is
+// moved aside to <name>.old.db first and only removed once the copy is in
+// place, so an interrupted swap can be recovered by recoverSwap.
+func (p *Pruner) swapDB(dir, name string) error {
+	cur := filepath.Join(dir, name+".db")
+	next := filepath.Join(dir, name+".new.db")
+	old := filepath.Join(dir, name+".old.db")
+
+	if err := os.Rename(cur, old); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(next, cur); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	p.logger.Info("replaced db with its pruned copy", "db", name)
+	return os.RemoveAll(old)
+}
+
+// syncDir flushes the entries of dir to disk, so renames in it survive a
+// crash.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// recoverSwap cleans up after a copy forward of the named database that was
+// interrupted: the original is restored if it was moved aside without the
+// copy taking its place, and leftover copies and originals are removed.
+func (p *Pruner) recoverSwap(dir, name string) error {
+	cur := filepath.Join(dir, name+".db")
+	next := filepath.Join(dir, name+".new.db")
+	old := filepath.Join(dir, name+".old.db")
+
+	if _, err := os.Stat(old); err == nil {
+		if _, err := os.Stat(cur); os.IsNotExist(err) {
+			p.logger.Info("restoring db moved aside by an interrupted copy", "db", name)
+			if err := os.Rename(old, cur); err != nil {
+				return err
+			}
+			if err := syncDir(dir); err != nil {
+				return err
+			}
+		} else if err := os.RemoveAll(old); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(next)
+}
@@ -0,0 +1,208 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	db "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	cmtstate "github.com/cometbft/cometbft/proto/tendermint/state"
+	sm "github.com/cometbft/cometbft/state"
+	tmstore "github.com/cometbft/cometbft/store"
+	"github.com/cometbft/cometbft/types"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// saveTestBlocks saves blocks 1 to n into a new block store in dir.
+func saveTestBlocks(t *testing.T, dir string, n int64) {
+	blockStoreDB, err := db.NewGoLevelDB("blockstore", dir)
+	require.NoError(t, err)
+	defer blockStoreDB.Close()
+	blockStore := tmstore.NewBlockStore(blockStoreDB)
+
+	pk := ed25519.GenPrivKey()
+	state, err := sm.MakeGenesisState(&types.GenesisDoc{
+		ChainID:     "test",
+		GenesisTime: time.Now(),
+		Validators:  []types.GenesisValidator{{PubKey: pk.PubKey(), Power: 10}},
+	})
+	require.NoError(t, err)
+
+	lastCommit := &types.Commit{}
+	for h := int64(1); h <= n; h++ {
+		block := state.MakeBlock(h, []types.Tx{types.Tx(fmt.Sprintf("tx-%d", h))}, lastCommit, nil, pk.PubKey().Address())
+		parts, err := block.MakePartSet(types.BlockPartSizeBytes)
+		require.NoError(t, err)
+		commit := &types.Commit{
+			Height:     h,
+			BlockID:    types.BlockID{Hash: block.Hash(), PartSetHeader: parts.Header()},
+			Signatures: []types.CommitSig{{BlockIDFlag: types.BlockIDFlagCommit, ValidatorAddress: pk.PubKey().Address(), Timestamp: time.Now(), Signature: make([]byte, 64)}},
+		}
+		blockStore.SaveBlock(block, parts, commit)
+		lastCommit = commit
+	}
+}
+
+func TestCopyForwardBlockStore(t *testing.T) {
+	dir := t.TempDir()
+	saveTestBlocks(t, dir, 20)
+
+	copied, err := newTestPruner(t, Options{DataDir: dir}).copyForwardBlockStore(context.Background(), dir, 15)
+	require.NoError(t, err)
+	require.EqualValues(t, 6, copied)
+
+	_, err = os.Stat(filepath.Join(dir, "blockstore.new.db"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "blockstore.old.db"))
+	require.True(t, os.IsNotExist(err))
+
+	blockStoreDB, err := db.NewGoLevelDB("blockstore", dir)
+	require.NoError(t, err)
+	defer blockStoreDB.Close()
+	blockStore := tmstore.NewBlockStore(blockStoreDB)
+	require.EqualValues(t, 15, blockStore.Base())
+	require.EqualValues(t, 20, blockStore.Height())
+	for h := int64(1); h <= 20; h++ {
+		block := blockStore.LoadBlock(h)
+		require.Equal(t, h >= 15, block != nil, "height %d", h)
+		if block != nil {
+			require.NotNil(t, blockStore.LoadBlockByHash(block.Hash()), "height %d", h)
+			require.NotNil(t, blockStore.LoadSeenCommit(h), "height %d", h)
+		}
+		// the commit of a height is saved with the block after it
+		if h >= 15 && h < 20 {
+			require.NotNil(t, blockStore.LoadBlockCommit(h), "height %d", h)
+		}
+	}
+}
+
+// saveTestStates saves the states of heights 1 to n into a new state store in
+// dir, changing the validator set every changeEvery heights.
+func saveTestStates(t *testing.T, dir string, n, changeEvery int64) {
+	stateDB, err := db.NewGoLevelDB("state", dir)
+	require.NoError(t, err)
+	defer stateDB.Close()
+	stateStore := sm.NewStore(stateDB, sm.StoreOptions{})
+
+	pk := ed25519.GenPrivKey()
+	st, err := sm.MakeGenesisState(&types.GenesisDoc{
+		ChainID:     "test",
+		GenesisTime: time.Now(),
+		Validators:  []types.GenesisValidator{{PubKey: pk.PubKey(), Power: 10}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, stateStore.Save(st))
+
+	for h := int64(1); h <= n; h++ {
+		require.NoError(t, stateStore.SaveABCIResponses(h, &cmtstate.ABCIResponses{BeginBlock: &abci.ResponseBeginBlock{}, EndBlock: &abci.ResponseEndBlock{}}))
+		st.LastBlockHeight = h
+		st.LastValidators = st.Validators.Copy()
+		st.Validators = st.NextValidators.Copy()
+		if h%changeEvery == 0 {
+			next := st.NextValidators.Copy()
+			require.NoError(t, next.UpdateWithChangeSet([]*types.Validator{types.NewValidator(pk.PubKey(), 10+h)}))
+			st.NextValidators = next
+			st.LastHeightValidatorsChanged = h + 2
+		}
+		st.NextValidators = st.NextValidators.CopyIncrementProposerPriority(1)
+		require.NoError(t, stateStore.Save(st))
+	}
+}
+
+func TestCopyForwardState(t *testing.T) {
+	dir := t.TempDir()
+	// the validators last changed at height 28, before the retained heights
+	saveTestStates(t, dir, 40, 13)
+
+	retained, err := newTestPruner(t, Options{DataDir: dir}).copyForwardState(context.Background(), dir, 30)
+	require.NoError(t, err)
+	require.EqualValues(t, 11, retained)
+
+	stateDB, err := db.NewGoLevelDB("state", dir)
+	require.NoError(t, err)
+	defer stateDB.Close()
+	stateStore := sm.NewStore(stateDB, sm.StoreOptions{})
+
+	st, err := stateStore.Load()
+	require.NoError(t, err)
+	require.EqualValues(t, 40, st.LastBlockHeight)
+	for h := int64(30); h <= 41; h++ {
+		_, err := stateStore.LoadValidators(h)
+		require.NoError(t, err, "height %d", h)
+		_, err = stateStore.LoadConsensusParams(h)
+		require.NoError(t, err, "height %d", h)
+	}
+	for h := int64(1); h < 30; h++ {
+		_, err := stateStore.LoadABCIResponses(h)
+		require.Error(t, err, "height %d", h)
+	}
+	_, err = stateStore.LoadABCIResponses(30)
+	require.NoError(t, err)
+}
+
+func TestRecoverSwap(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestPruner(t, Options{DataDir: dir})
+	mkdir := func(name string) {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0o755))
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	// interrupted after moving the original aside: it is restored
+	mkdir("blockstore.old.db")
+	mkdir("blockstore.new.db")
+	require.NoError(t, p.recoverSwap(dir, "blockstore"))
+	require.True(t, exists("blockstore.db"))
+	require.False(t, exists("blockstore.old.db"))
+	require.False(t, exists("blockstore.new.db"))
+
+	// interrupted after the copy took its place: the original is removed
+	mkdir("blockstore.old.db")
+	require.NoError(t, p.recoverSwap(dir, "blockstore"))
+	require.True(t, exists("blockstore.db"))
+	require.False(t, exists("blockstore.old.db"))
+}
+
+func TestUseCopyForward(t *testing.T) {
+	p := newTestPruner(t, Options{DataDir: t.TempDir()})
+	require.True(t, p.useCopyForward(0.1))
+	require.False(t, p.useCopyForward(0.5))
+
+	p.opts.Strategy = StrategyDelete
+	require.False(t, p.useCopyForward(0.1))
+
+	p.opts.Strategy = StrategyCopy
+	require.True(t, p.useCopyForward(0.9))
+}
+
+func TestPrefixGaps(t *testing.T) {
+	gaps := prefixGaps([][]byte{[]byte("H:"), []byte("BH:"), []byte("C:")})
+	require.Equal(t, []util.Range{
+		{Start: nil, Limit: []byte("BH:")},
+		{Start: []byte("BH;"), Limit: []byte("C:")},
+		{Start: []byte("C;"), Limit: []byte("H:")},
+		{Start: []byte("H;")},
+	}, gaps)
+}
+
+func TestCopyRangeCancelled(t *testing.T) {
+	src, dst := db.NewMemDB(), db.NewMemDB()
+	for i := 0; i < 2*copyBatchKeys; i++ {
+		require.NoError(t, src.Set([]byte(fmt.Sprintf("H:%d", i)), []byte("block")))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// keys read but not kept still stop a cancelled copy
+	err := copyRange(ctx, src, dst, util.Range{}, func([]byte) bool { return false })
+	require.ErrorIs(t, err, context.Canceled)
+}
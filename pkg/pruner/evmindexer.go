@@ -0,0 +1,72 @@
+package pruner
+
+import (
+	"context"
+	"encoding/binary"
+
+	db "github.com/cometbft/cometbft-db"
+)
+
+// The Ethermint JSON-RPC indexer keeps evmindexer.db next to application.db.
+// It indexes every Ethereum tx twice: by hash under 0x01<hash>, with the tx
+// result as value, and by position under 0x02<height><tx index>, both 8 byte
+// big endian, with the tx hash as value.
+const (
+	evmTxHashPrefix  = 0x01
+	evmTxIndexPrefix = 0x02
+)
+
+// pruneEVMIndexer deletes the txs indexed below pruneHeight from the EVM
+// indexer, returning the number of keys deleted. It stops between batches
+// when ctx is cancelled.
+func pruneEVMIndexer(ctx context.Context, indexerDB db.DB, pruneHeight int64) (int, error) {
+	end := make([]byte, 1+8)
+	end[0] = evmTxIndexPrefix
+	binary.BigEndian.PutUint64(end[1:], uint64(pruneHeight))
+	itr, err := indexerDB.Iterator([]byte{evmTxIndexPrefix}, end)
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	batch := indexerDB.NewBatch()
+	defer func() { batch.Close() }()
+
+	var deleted, pending int
+	del := func(key []byte) error {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+		deleted++
+		pending++
+		if pending < txIndexBatchSize {
+			return nil
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Close()
+		batch, pending = indexerDB.NewBatch(), 0
+		return ctx.Err()
+	}
+
+	for ; itr.Valid(); itr.Next() {
+		if err := del(itr.Key()); err != nil {
+			return deleted, err
+		}
+		// the position entry of a tx holds its hash
+		if err := del(append([]byte{evmTxHashPrefix}, itr.Value()...)); err != nil {
+			return deleted, err
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return deleted, err
+	}
+
+	if pending > 0 {
+		if err := batch.Write(); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,38 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneEVMIndexer(t *testing.T) {
+	indexerDB := db.NewMemDB()
+	txHash := func(height, index int) []byte {
+		return bytes.Repeat([]byte{byte(height*10 + index)}, 32)
+	}
+	for height := 1; height <= 20; height++ {
+		for index := 0; index < 2; index++ {
+			key := []byte{evmTxIndexPrefix}
+			key = binary.BigEndian.AppendUint64(key, uint64(height))
+			key = binary.BigEndian.AppendUint64(key, uint64(index))
+			require.NoError(t, indexerDB.Set(key, txHash(height, index)))
+			require.NoError(t, indexerDB.Set(append([]byte{evmTxHashPrefix}, txHash(height, index)...), []byte("result")))
+		}
+	}
+
+	deleted, err := pruneEVMIndexer(context.Background(), indexerDB, 15)
+	require.NoError(t, err)
+	require.Equal(t, 14*2*2, deleted)
+	for height := 1; height <= 20; height++ {
+		for index := 0; index < 2; index++ {
+			has, err := indexerDB.Has(append([]byte{evmTxHashPrefix}, txHash(height, index)...))
+			require.NoError(t, err)
+			require.Equal(t, height >= 15, has, "height %d", height)
+		}
+	}
+}
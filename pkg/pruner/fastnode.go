@@ -0,0 +1,117 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+
+	db "github.com/cometbft/cometbft-db"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/diskusage"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+)
+
+const (
+	// fastNodePrefix is the IAVL key prefix of fast nodes within a store.
+	fastNodePrefix = "f"
+	// storageVersionKey is the IAVL metadata key marking a store's fast nodes
+	// as built up to a version. Without it IAVL rebuilds them on load.
+	storageVersionKey = "mstorage_version"
+)
+
+// DropFastNodes deletes the IAVL fast nodes and the storage version marker of
+// the named application stores, or of every store in the latest commit info
+// when stores is empty, compacts their key ranges and returns the bytes
+// reclaimed.
+func (p *Pruner) DropFastNodes(ctx context.Context, stores []string) (int64, error) {
+	appDB, err := p.openDB("application", p.opts.DataDir, false)
+	if err != nil {
+		return 0, err
+	}
+	defer appDB.Close()
+
+	if len(stores) == 0 {
+		if stores, err = rootmulti.LatestStoreNames(appDB); err != nil {
+			return 0, err
+		}
+	}
+
+	var reclaimed int64
+	for _, name := range stores {
+		n, err := p.dropFastNodes(ctx, appDB, name)
+		if err != nil {
+			return reclaimed, fmt.Errorf("store %s: %w", name, err)
+		}
+		reclaimed += n
+	}
+	p.logger.Info("dropping fast nodes complete", "stores", len(stores), "reclaimed", reclaimed)
+	return reclaimed, nil
+}
+
+// RebuildFastNodes drops the fast nodes of the named application stores, or
+// of every store in the latest commit info when stores is empty, and loads
+// them with fast nodes enabled, which makes IAVL build them from the latest
+// version.
+func (p *Pruner) RebuildFastNodes(ctx context.Context, stores []string) error {
+	appDB, err := p.openDB("application", p.opts.DataDir, false)
+	if err != nil {
+		return err
+	}
+	defer appDB.Close()
+
+	if len(stores) == 0 {
+		if stores, err = rootmulti.LatestStoreNames(appDB); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range stores {
+		if _, err := p.dropFastNodes(ctx, appDB, name); err != nil {
+			return fmt.Errorf("store %s: %w", name, err)
+		}
+	}
+
+	appStore := rootmulti.NewStore(throttle.NewDB(appDB, p.ioLimiter), p.logger)
+	appStore.SetConcurrency(p.opts.Concurrency)
+	appStore.SetIAVLDisableFastNode(false)
+	appStore.SetIAVLCacheSize(p.IAVLCacheSize(len(stores)))
+	for _, name := range stores {
+		appStore.MountStoreWithDB(storetypes.NewKVStoreKey(name), storetypes.StoreTypeIAVL, nil)
+	}
+
+	p.logger.Info("rebuilding fast nodes", "stores", stores)
+	if err := appStore.LoadLatestVersion(); err != nil {
+		return err
+	}
+
+	for _, name := range stores {
+		size, err := diskusage.PrefixSize(appDB, append(rootmulti.StorePrefix(name), fastNodePrefix...))
+		if err != nil {
+			return err
+		}
+		p.logger.Info("rebuilt fast nodes", "store", name, "size", size)
+	}
+	return nil
+}
+
+// dropFastNodes deletes the fast nodes and the storage version marker of the
+// named store, compacts the fast node key range and returns the bytes reclaimed.
+func (p *Pruner) dropFastNodes(ctx context.Context, appDB *db.GoLevelDB, name string) (int64, error) {
+	prefix := append(rootmulti.StorePrefix(name), fastNodePrefix...)
+	database := throttle.NewDB(appDB, p.ioLimiter)
+	deleted, err := deletePrefix(ctx, database, prefix)
+	if err != nil {
+		return 0, err
+	}
+	if err := database.Delete(append(rootmulti.StorePrefix(name), storageVersionKey...)); err != nil {
+		return 0, err
+	}
+
+	reclaimed, err := p.compactPrefix(ctx, "application", appDB, p.opts.DataDir, prefix)
+	if err != nil {
+		return 0, err
+	}
+	p.logger.Info("dropped fast nodes", "store", name, "keys", deleted, "reclaimed", reclaimed)
+	return reclaimed, nil
+}
@@ -0,0 +1,28 @@
+package pruner
+
+import (
+	"context"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDropAndRebuildFastNodes(t *testing.T) {
+	dir := t.TempDir()
+	saveTestAppState(t, dir, 20, "acc", "bank")
+	p := newTestPruner(t, Options{DataDir: dir})
+
+	// every store by default
+	_, err := p.DropFastNodes(context.Background(), nil)
+	require.NoError(t, err)
+	require.NoError(t, p.RebuildFastNodes(context.Background(), []string{"bank"}))
+
+	appDB, err := db.NewGoLevelDB("application", dir)
+	require.NoError(t, err)
+	defer appDB.Close()
+	require.Zero(t, countPrefix(t, appDB, "s/k:acc/f"))
+	require.Zero(t, countPrefix(t, appDB, "s/k:acc/"+storageVersionKey))
+	require.Equal(t, 1, countPrefix(t, appDB, "s/k:bank/f"))
+	require.Equal(t, 1, countPrefix(t, appDB, "s/k:bank/"+storageVersionKey))
+}
@@ -0,0 +1,215 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	db "github.com/cometbft/cometbft-db"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/legacyiavl"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+)
+
+// separateStorePrefix is the prefix of the IAVL tree of a store kept in a
+// database of its own.
+var separateStorePrefix = []byte("s/_/")
+
+// storeTreeDB returns the database the IAVL tree of the named store is in:
+// the s/_/ prefix of its own database in storeDBs, if it has one, or its
+// s/k:<name>/ prefix in appDB.
+func storeTreeDB(appDB db.DB, storeDBs map[string]db.DB, name string) db.DB {
+	if storeDB, ok := storeDBs[name]; ok {
+		return db.NewPrefixDB(storeDB, separateStorePrefix)
+	}
+	return db.NewPrefixDB(appDB, rootmulti.StorePrefix(name))
+}
+
+// LegacyStore is the IAVL layout of an application store.
+type LegacyStore struct {
+	Name string
+	// DB is the database the store is kept in, application unless it has a
+	// database of its own.
+	DB string
+	// FirstVersion and LatestVersion are the first and the latest versions
+	// with a legacy root, 0 when there are none.
+	FirstVersion  int64
+	LatestVersion int64
+	// LegacyNodes is set when nodes in the legacy layout remain.
+	LegacyNodes bool
+	// V1LatestVersion is the latest version in the v1 layout, 0 when there
+	// is none.
+	V1LatestVersion int64
+}
+
+// Legacy reports whether any part of the store is in the legacy layout.
+func (s LegacyStore) Legacy() bool {
+	return s.LatestVersion > 0 || s.LegacyNodes
+}
+
+// LegacyStores inspects, read only, the layout of every store in the latest
+// commit info, in application.db or in the database of its own the app
+// profile or Options.StoreDBs give it.
+func (p *Pruner) LegacyStores() ([]LegacyStore, error) {
+	appDB, err := p.openDB("application", p.opts.DataDir, true)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+
+	names, err := rootmulti.LatestStoreNames(appDB)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*storetypes.KVStoreKey, len(names))
+	for _, name := range names {
+		keys[name] = storetypes.NewKVStoreKey(name)
+	}
+	dbNames, err := p.profileStoreDBs()
+	if err != nil {
+		return nil, err
+	}
+	separateDBs, err := p.openStoreDBs(p.opts.DataDir, keys, true)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStoreDBs(separateDBs)
+	storeDBs := make(map[string]db.DB, len(separateDBs))
+	for name, database := range separateDBs {
+		storeDBs[name] = database
+	}
+
+	stores := make([]LegacyStore, 0, len(names))
+	for _, name := range names {
+		info, err := legacyiavl.Inspect(storeTreeDB(appDB, storeDBs, name))
+		if err != nil {
+			return nil, fmt.Errorf("store %s: %w", name, err)
+		}
+		dbName := "application"
+		if _, ok := storeDBs[name]; ok {
+			dbName = dbNames[name]
+		}
+		stores = append(stores, LegacyStore{
+			Name:            name,
+			DB:              dbName,
+			FirstVersion:    info.FirstVersion,
+			LatestVersion:   info.LatestVersion,
+			LegacyNodes:     info.LegacyNodes,
+			V1LatestVersion: info.V1LatestVersion,
+		})
+	}
+	return stores, nil
+}
+
+// MigrateLegacy migrates the named application stores from the IAVL
+// v0.19/v0.20 layout to the v1 layout, and checks that each still loads with
+// the hash of the latest commit info.
+func (p *Pruner) MigrateLegacy(ctx context.Context, stores []string) error {
+	appDB, err := p.openDB("application", p.opts.DataDir, false)
+	if err != nil {
+		return err
+	}
+	defer appDB.Close()
+
+	keys := make(map[string]*storetypes.KVStoreKey, len(stores))
+	for _, name := range stores {
+		keys[name] = storetypes.NewKVStoreKey(name)
+	}
+	separateDBs, err := p.openStoreDBs(p.opts.DataDir, keys, false)
+	if err != nil {
+		return err
+	}
+	defer closeStoreDBs(separateDBs)
+	storeDBs := make(map[string]db.DB, len(separateDBs))
+	for name, database := range separateDBs {
+		storeDBs[name] = database
+	}
+	return p.migrateLegacyStores(ctx, appDB, storeDBs, stores)
+}
+
+// pruneLegacyStores deletes the legacy versions up to toVersion of the named
+// stores, which IAVL v1 leaves in place, and migrates the stores to the v1
+// layout with Options.MigrateLegacy. It runs before the stores are loaded. Stores
+// in storeDBs are kept in databases of their own.
+func (p *Pruner) pruneLegacyStores(ctx context.Context, appDB db.DB, storeDBs map[string]db.DB, names []string, toVersion int64) error {
+	database := throttle.NewDB(appDB, p.ioLimiter)
+
+	var (
+		total  legacyiavl.Stats
+		legacy []string
+	)
+	for _, name := range names {
+		storeDB := storeTreeDB(database, storeDBs, name)
+		info, err := legacyiavl.Inspect(storeDB)
+		if err != nil {
+			return fmt.Errorf("store %s: %w", name, err)
+		}
+		if !info.Legacy() {
+			continue
+		}
+		legacy = append(legacy, name)
+
+		p.logger.Info("pruning legacy IAVL versions", "store", name, "legacy_versions", info.Versions, "latest_legacy", info.LatestVersion)
+		stats, err := legacyiavl.DeleteVersionsTo(ctx, storeDB, toVersion)
+		if err != nil {
+			return fmt.Errorf("store %s: %w", name, err)
+		}
+		p.logger.Info("pruned legacy IAVL versions", "store", name, "roots", stats.Roots, "orphans", stats.Orphans, "nodes", stats.Nodes)
+		total = total.Add(stats)
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+	p.logger.Info("pruning legacy IAVL versions complete", "stores", len(legacy),
+		"roots", total.Roots, "orphans", total.Orphans, "nodes", total.Nodes)
+
+	if !p.opts.MigrateLegacy {
+		return nil
+	}
+	return p.migrateLegacyStores(ctx, appDB, storeDBs, legacy)
+}
+
+// migrateLegacyStores migrates the named stores to the v1 layout, logging the
+// keys converted and removed, and checks that each store still loads with the
+// hash of the latest commit info.
+func (p *Pruner) migrateLegacyStores(ctx context.Context, appDB db.DB, storeDBs map[string]db.DB, names []string) error {
+	database := throttle.NewDB(appDB, p.ioLimiter)
+
+	var total legacyiavl.Stats
+	for _, name := range names {
+		p.logger.Info("migrating store to the IAVL v1 layout", "store", name)
+		stats, err := legacyiavl.Migrate(ctx, storeTreeDB(database, storeDBs, name))
+		if err != nil {
+			return fmt.Errorf("store %s: %w", name, err)
+		}
+		p.logger.Info("migrated store to the IAVL v1 layout", "store", name, "converted", stats.Converted, "rewritten", stats.Rewritten,
+			"roots", stats.Roots, "orphans", stats.Orphans, "nodes", stats.Nodes)
+		total = total.Add(stats)
+	}
+
+	latest := rootmulti.GetLatestVersion(appDB)
+	cInfo, err := rootmulti.NewStore(appDB, p.logger).GetCommitInfo(latest)
+	if err != nil {
+		return err
+	}
+	appStore, err := rootmulti.LoadStoresAt(appDB, p.logger, storeDBs, latest, names...)
+	if err != nil {
+		return err
+	}
+	for _, info := range cInfo.StoreInfos {
+		store, ok := appStore.GetStoreByName(info.Name).(storetypes.CommitKVStore)
+		if !ok {
+			continue
+		}
+		if hash := store.LastCommitID().Hash; !bytes.Equal(hash, info.CommitId.Hash) {
+			return fmt.Errorf("store %s: migrated hash %X does not match the commit info hash %X at version %d",
+				info.Name, hash, info.CommitId.Hash, latest)
+		}
+	}
+
+	p.logger.Info("migrating stores to the IAVL v1 layout complete", "stores", len(names),
+		"converted", total.Converted, "rewritten", total.Rewritten, "removed", total.Roots+total.Orphans+total.Nodes)
+	return nil
+}
@@ -0,0 +1,25 @@
+package pruner
+
+import (
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLegacyStores(t *testing.T) {
+	dir := t.TempDir()
+	wasmDB, err := db.NewGoLevelDB("wasm", dir)
+	require.NoError(t, err)
+	saveTestAppStateWith(t, dir, 20, map[string]db.DB{"wasm": wasmDB}, nil, "bank", "wasm")
+	require.NoError(t, wasmDB.Close())
+
+	// the store kept in a database of its own is inspected there
+	p := newTestPruner(t, Options{DataDir: dir, StoreDBs: map[string]string{"wasm": "wasm.db"}})
+	stores, err := p.LegacyStores()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []LegacyStore{
+		{Name: "bank", DB: "application", V1LatestVersion: 20},
+		{Name: "wasm", DB: "wasm", V1LatestVersion: 20},
+	}, stores)
+}
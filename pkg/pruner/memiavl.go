@@ -0,0 +1,161 @@
+package pruner
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/diskusage"
+)
+
+// Nodes running memiavl, like Cronos, keep the application state in
+// memiavl.db instead of IAVL nodes in application.db: a directory of
+// snapshots named snapshot-<version>, a current symlink to the one in use and
+// a WAL of the blocks since. versiondb keeps the historical key values for
+// queries in versiondb, a RocksDB database.
+const (
+	memIAVLDir       = "memiavl.db"
+	memIAVLCurrent   = "current"
+	memIAVLSnapshot  = "snapshot-"
+	memIAVLTmpSuffix = "-tmp"
+	versionDBDir     = "versiondb"
+)
+
+// memIAVLSnapshotDir is a snapshot directory of memiavl.
+type memIAVLSnapshotDir struct {
+	version int64
+	name    string
+}
+
+// hasMemIAVL reports whether the data directory keeps its application state
+// in memiavl.
+func hasMemIAVL(dbDir string) bool {
+	info, err := os.Stat(filepath.Join(dbDir, memIAVLDir))
+	return err == nil && info.IsDir()
+}
+
+// memIAVLSnapshots returns the version of the snapshot in use, all snapshots
+// by ascending version, and the leftovers of interrupted snapshots in dir.
+func (p *Pruner) memIAVLSnapshots(dir string) (current int64, snapshots []memIAVLSnapshotDir, tmp []string, err error) {
+	target, err := os.Readlink(filepath.Join(dir, memIAVLCurrent))
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("memiavl has no current snapshot: %w", err)
+	}
+	if current, err = parseMemIAVLSnapshot(filepath.Base(target)); err != nil {
+		return 0, nil, nil, fmt.Errorf("memiavl current snapshot %s: %w", target, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || !strings.HasPrefix(name, memIAVLSnapshot) {
+			continue
+		}
+		if strings.HasSuffix(name, memIAVLTmpSuffix) {
+			tmp = append(tmp, name)
+			continue
+		}
+		version, err := parseMemIAVLSnapshot(name)
+		if err != nil {
+			p.logger.Info("skipping unknown memiavl directory", "dir", name)
+			continue
+		}
+		snapshots = append(snapshots, memIAVLSnapshotDir{version: version, name: name})
+	}
+	slices.SortFunc(snapshots, func(a, b memIAVLSnapshotDir) int { return cmp.Compare(a.version, b.version) })
+	return current, snapshots, tmp, nil
+}
+
+func parseMemIAVLSnapshot(name string) (int64, error) {
+	return strconv.ParseInt(strings.TrimPrefix(name, memIAVLSnapshot), 10, 64)
+}
+
+// pruneMemIAVLSnapshots removes the snapshots older than the current one
+// beyond the keepRecent most recent, and the leftovers of interrupted
+// snapshots. Snapshots newer than the current one are kept. It returns the
+// versions removed and the bytes reclaimed.
+func (p *Pruner) pruneMemIAVLSnapshots(ctx context.Context, dir string, keepRecent int) (removed []int64, reclaimed int64, err error) {
+	current, snapshots, tmp, err := p.memIAVLSnapshots(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !slices.ContainsFunc(snapshots, func(s memIAVLSnapshotDir) bool { return s.version == current }) {
+		return nil, 0, fmt.Errorf("memiavl current snapshot %d does not exist", current)
+	}
+
+	var older []memIAVLSnapshotDir
+	for _, snapshot := range snapshots {
+		if snapshot.version < current {
+			older = append(older, snapshot)
+		}
+	}
+	remove := func(name string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, name)
+		size, err := diskusage.DirSize(path)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		reclaimed += size
+		return nil
+	}
+	for _, snapshot := range older[:max(len(older)-keepRecent, 0)] {
+		if err := remove(snapshot.name); err != nil {
+			return removed, reclaimed, err
+		}
+		removed = append(removed, snapshot.version)
+	}
+	for _, name := range tmp {
+		p.logger.Info("removing interrupted memiavl snapshot", "dir", name)
+		if err := remove(name); err != nil {
+			return removed, reclaimed, err
+		}
+	}
+	return removed, reclaimed, nil
+}
+
+// pruneMemIAVL is the app phase of nodes running memiavl: it prunes the
+// memiavl snapshots instead of loading IAVL stores that are not there.
+func (p *Pruner) pruneMemIAVL(ctx context.Context) error {
+	p.logger.Info("the application state is kept in memiavl, application.db holds no IAVL stores to prune")
+
+	dir := filepath.Join(p.opts.DataDir, memIAVLDir)
+	p.logger.Info("pruning memiavl snapshots", "dir", dir, "keep_recent", p.opts.MemIAVLKeepRecent)
+	removed, reclaimed, err := p.pruneMemIAVLSnapshots(ctx, dir, p.opts.MemIAVLKeepRecent)
+	if err != nil {
+		return err
+	}
+	p.logger.Info("pruning memiavl snapshots complete", "removed", len(removed), "reclaimed", reclaimed)
+	return nil
+}
+
+// reportVersionDB logs the size of the versiondb of the data directory, if
+// it has one, and that its history is left in place. Nodes run it with
+// memiavl or IAVL. versiondb is a RocksDB database and cosmprund is built with
+// goleveldb only, without the cgo RocksDB bindings, so it cannot open it, let
+// alone delete the history below the retained versions.
+func (p *Pruner) reportVersionDB(dbDir string) {
+	dir := filepath.Join(dbDir, versionDBDir)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return
+	}
+	size, err := diskusage.DirSize(dir)
+	if err != nil {
+		p.logger.Error("failed to read versiondb size", "dir", dir, "err", err)
+		return
+	}
+	p.logger.Info("versiondb is a RocksDB database, which cosmprund cannot open, so its history is not pruned", "dir", dir, "size", size)
+}
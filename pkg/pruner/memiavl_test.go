@@ -0,0 +1,45 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneMemIAVLSnapshots(t *testing.T) {
+	dbDir := t.TempDir()
+	p := newTestPruner(t, Options{DataDir: dbDir})
+	dir := filepath.Join(dbDir, memIAVLDir)
+	for _, name := range []string{"snapshot-100", "snapshot-200", "snapshot-300", "snapshot-400", "snapshot-500", "snapshot-600-tmp", "wal"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name, "data"), make([]byte, 10), 0o644))
+	}
+	// snapshot-500 was written but the node stopped before switching to it
+	require.NoError(t, os.Symlink("snapshot-400", filepath.Join(dir, memIAVLCurrent)))
+	require.True(t, hasMemIAVL(dbDir))
+
+	removed, reclaimed, err := p.pruneMemIAVLSnapshots(context.Background(), dir, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{100, 200}, removed)
+	require.EqualValues(t, 30, reclaimed)
+
+	for name, kept := range map[string]bool{
+		"snapshot-100": false, "snapshot-200": false, "snapshot-300": true, "snapshot-400": true,
+		"snapshot-500": true, "snapshot-600-tmp": false, "wal": true, memIAVLCurrent: true,
+	} {
+		_, err := os.Lstat(filepath.Join(dir, name))
+		require.Equal(t, kept, err == nil, fmt.Sprintf("%s: %v", name, err))
+	}
+
+	// a current snapshot that is gone is an error, nothing is removed
+	require.NoError(t, os.Remove(filepath.Join(dir, memIAVLCurrent)))
+	require.NoError(t, os.Symlink("snapshot-900", filepath.Join(dir, memIAVLCurrent)))
+	_, _, err = p.pruneMemIAVLSnapshots(context.Background(), dir, 0)
+	require.Error(t, err)
+	_, err = os.Stat(filepath.Join(dir, "snapshot-300"))
+	require.NoError(t, err)
+}
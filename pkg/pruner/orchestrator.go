@@ -0,0 +1,210 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cometbft/cometbft/libs/log"
+)
+
+// PhaseStatus is the state of a prune phase.
+type PhaseStatus string
+
+const (
+	PhasePending PhaseStatus = "pending"
+	PhaseRunning PhaseStatus = "running"
+	PhaseDone    PhaseStatus = "done"
+	PhaseFailed  PhaseStatus = "failed"
+	// PhaseSkipped is set on phases that did not run because a dependency did
+	// not complete or another phase failed first.
+	PhaseSkipped PhaseStatus = "skipped"
+)
+
+// Phase is one unit of a prune run, such as pruning and compacting a database.
+type Phase struct {
+	Name     string        `json:"name"`
+	Deps     []string      `json:"deps,omitempty"`
+	Status   PhaseStatus   `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Seconds  float64       `json:"seconds"`
+	Started  time.Time     `json:"-"`
+	Duration time.Duration `json:"-"`
+
+	run  func(ctx context.Context) error
+	done chan struct{}
+}
+
+// orchestrator runs phases concurrently as a dependency graph. A phase starts
+// once all its dependencies are done; the first failure cancels the shared
+// context so running phases stop at their next check and pending ones are
+// skipped.
+type orchestrator struct {
+	logger   log.Logger
+	progress func(Progress)
+
+	mu     sync.Mutex
+	phases []*Phase
+	byName map[string]*Phase
+	// err is the first phase add rejected, returned by Run.
+	err error
+}
+
+// newOrchestrator returns an orchestrator that logs to logger and reports
+// every status change to progress, if set.
+func newOrchestrator(logger log.Logger, progress func(Progress)) *orchestrator {
+	return &orchestrator{logger: logger, progress: progress, byName: make(map[string]*Phase)}
+}
+
+// add registers a phase that runs after the phases named in deps. A second
+// phase with the same name is rejected, and Run fails without starting any.
+func (o *orchestrator) add(name string, run func(ctx context.Context) error, deps ...string) {
+	if _, ok := o.byName[name]; ok {
+		if o.err == nil {
+			o.err = fmt.Errorf("phase %s added twice", name)
+		}
+		return
+	}
+	p := &Phase{Name: name, Deps: deps, Status: PhasePending, run: run, done: make(chan struct{})}
+	o.phases = append(o.phases, p)
+	o.byName[name] = p
+}
+
+// Run runs all phases and returns their errors joined together. It fails
+// before starting any phase when a phase was rejected, depends on an unknown
+// phase or on itself through a dependency cycle.
+func (o *orchestrator) Run(ctx context.Context) error {
+	if o.err != nil {
+		return o.err
+	}
+	if err := o.checkGraph(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(o.phases))
+	)
+	for i, p := range o.phases {
+		wg.Add(1)
+		go func(i int, p *Phase) {
+			defer wg.Done()
+			defer close(p.done)
+
+			for _, dep := range p.Deps {
+				d := o.byName[dep]
+				<-d.done
+				if o.status(d) != PhaseDone {
+					o.setStatus(p, PhaseSkipped, fmt.Errorf("dependency %s %s", dep, o.status(d)))
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				o.setStatus(p, PhaseSkipped, ctx.Err())
+				return
+			}
+
+			o.mu.Lock()
+			p.Started = time.Now()
+			o.mu.Unlock()
+			o.setStatus(p, PhaseRunning, nil)
+			o.logger.Info("phase started", "phase", p.Name)
+
+			err := p.run(ctx)
+
+			o.mu.Lock()
+			p.Duration = time.Since(p.Started).Round(time.Millisecond)
+			p.Seconds = p.Duration.Seconds()
+			o.mu.Unlock()
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", p.Name, err)
+				o.setStatus(p, PhaseFailed, err)
+				cancel()
+				return
+			}
+			o.setStatus(p, PhaseDone, nil)
+			o.logger.Info("phase complete", "phase", p.Name, "duration", p.Duration)
+		}(i, p)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// checkGraph checks that every dependency is a known phase and that the
+// dependencies have no cycle, whose phases would wait on each other forever.
+func (o *orchestrator) checkGraph() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(o.phases))
+	var visit func(p *Phase, path []string) error
+	visit = func(p *Phase, path []string) error {
+		switch state[p.Name] {
+		case visiting:
+			return fmt.Errorf("phase dependency cycle: %s", strings.Join(append(path, p.Name), " -> "))
+		case visited:
+			return nil
+		}
+		state[p.Name] = visiting
+		for _, dep := range p.Deps {
+			d, ok := o.byName[dep]
+			if !ok {
+				return fmt.Errorf("phase %s depends on unknown phase %s", p.Name, dep)
+			}
+			if err := visit(d, append(path, p.Name)); err != nil {
+				return err
+			}
+		}
+		state[p.Name] = visited
+		return nil
+	}
+	for _, p := range o.phases {
+		if err := visit(p, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *orchestrator) status(p *Phase) PhaseStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return p.Status
+}
+
+func (o *orchestrator) setStatus(p *Phase, status PhaseStatus, err error) {
+	o.mu.Lock()
+	p.Status = status
+	if err != nil {
+		p.Error = err.Error()
+	}
+	progress := Progress{Phase: p.Name, Status: status, Err: err}
+	if status == PhaseDone || status == PhaseFailed {
+		progress.Elapsed = p.Duration
+	}
+	o.mu.Unlock()
+
+	if o.progress != nil {
+		o.progress(progress)
+	}
+}
+
+// log logs the status of every phase.
+func (o *orchestrator) log() {
+	for _, p := range o.phases {
+		if p.Error != "" {
+			o.logger.Info("phase report", "phase", p.Name, "status", p.Status, "duration", p.Duration, "err", p.Error)
+		} else {
+			o.logger.Info("phase report", "phase", p.Name, "status", p.Status, "duration", p.Duration)
+		}
+	}
+}
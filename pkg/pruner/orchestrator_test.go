@@ -0,0 +1,80 @@
+package pruner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cometbft/cometbft/libs/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrchestratorDependencies(t *testing.T) {
+	var order []string
+	o := newOrchestrator(log.NewNopLogger(), nil)
+	o.add("state", func(context.Context) error { order = append(order, "state"); return nil }, "blockstore")
+	o.add("blockstore", func(context.Context) error { order = append(order, "blockstore"); return nil })
+
+	require.NoError(t, o.Run(context.Background()))
+	require.Equal(t, []string{"blockstore", "state"}, order)
+	for _, p := range o.phases {
+		require.Equal(t, PhaseDone, p.Status, p.Name)
+	}
+}
+
+func TestOrchestratorFailure(t *testing.T) {
+	errBlocks := errors.New("blocks failed")
+	errApp := errors.New("app failed")
+	appStarted := make(chan struct{})
+	o := newOrchestrator(log.NewNopLogger(), nil)
+	o.add("blockstore", func(context.Context) error {
+		<-appStarted
+		return errBlocks
+	})
+	o.add("state", func(context.Context) error { return nil }, "blockstore")
+	// the app phase is independent, it sees the shared cancellation
+	o.add("app", func(ctx context.Context) error {
+		close(appStarted)
+		<-ctx.Done()
+		return errApp
+	})
+
+	err := o.Run(context.Background())
+	require.ErrorIs(t, err, errBlocks)
+	require.ErrorIs(t, err, errApp)
+
+	require.Equal(t, PhaseFailed, o.byName["blockstore"].Status)
+	require.Equal(t, PhaseSkipped, o.byName["state"].Status)
+	require.Equal(t, PhaseFailed, o.byName["app"].Status)
+
+	// phases that never started leave the databases as they were
+	require.True(t, (&Report{Phases: o.phases}).Modified())
+	require.False(t, (&Report{Phases: []*Phase{{Status: PhasePending}, {Status: PhaseSkipped}}}).Modified())
+}
+
+func TestOrchestratorUnknownDependency(t *testing.T) {
+	o := newOrchestrator(log.NewNopLogger(), nil)
+	o.add("state", func(context.Context) error { return nil }, "blockstore")
+	require.Error(t, o.Run(context.Background()))
+}
+
+func TestOrchestratorDuplicatePhase(t *testing.T) {
+	o := newOrchestrator(log.NewNopLogger(), nil)
+	ran := false
+	o.add("app", func(context.Context) error { ran = true; return nil })
+	o.add("app", func(context.Context) error { return nil })
+	require.ErrorContains(t, o.Run(context.Background()), "phase app added twice")
+	require.False(t, ran)
+}
+
+func TestOrchestratorDependencyCycle(t *testing.T) {
+	o := newOrchestrator(log.NewNopLogger(), nil)
+	ran := false
+	o.add("independent", func(context.Context) error { ran = true; return nil })
+	o.add("blockstore", func(context.Context) error { return nil }, "tx_index")
+	o.add("state", func(context.Context) error { return nil }, "blockstore")
+	o.add("tx_index", func(context.Context) error { return nil }, "state")
+	// fails instead of waiting forever, before any phase starts
+	require.ErrorContains(t, o.Run(context.Background()), "blockstore -> tx_index -> state -> blockstore")
+	require.False(t, ran)
+}
@@ -0,0 +1,143 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/diskusage"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+)
+
+// deleteBatchKeys is the number of keys deletePrefix deletes per batch.
+const deleteBatchKeys = 10000
+
+// OrphanStore is a store prefix in application.db that no retained commit
+// info lists.
+type OrphanStore struct {
+	Name string
+	Size int64
+}
+
+// OrphanStores returns the stores with data in application.db that are in
+// none of the retained commit infos. A store removed by an upgrade is still
+// referenced by the versions retained from before the upgrade, and its data
+// must stay until they are pruned.
+func (p *Pruner) OrphanStores() ([]OrphanStore, error) {
+	appDB, err := p.openDB("application", p.opts.DataDir, true)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+	return findOrphanStores(appDB)
+}
+
+// DeleteOrphanStores deletes the data of the stores OrphanStores returns,
+// compacts their key ranges and returns the orphans with their sizes before
+// deletion.
+func (p *Pruner) DeleteOrphanStores(ctx context.Context) ([]OrphanStore, error) {
+	appDB, err := p.openDB("application", p.opts.DataDir, false)
+	if err != nil {
+		return nil, err
+	}
+	defer appDB.Close()
+
+	orphans, err := findOrphanStores(appDB)
+	if err != nil {
+		return nil, err
+	}
+
+	database := throttle.NewDB(appDB, p.ioLimiter)
+	for _, o := range orphans {
+		prefix := rootmulti.StorePrefix(o.Name)
+		p.logger.Info("deleting orphaned store", "store", o.Name, "size", o.Size)
+		deleted, err := deletePrefix(ctx, database, prefix)
+		if err != nil {
+			return orphans, err
+		}
+
+		reclaimed, err := p.compactPrefix(ctx, "application", appDB, p.opts.DataDir, prefix)
+		if err != nil {
+			return orphans, err
+		}
+		p.logger.Info("deleted orphaned store", "store", o.Name, "keys", deleted, "reclaimed", reclaimed)
+	}
+	return orphans, nil
+}
+
+// findOrphanStores returns the orphaned stores of appDB with their sizes.
+func findOrphanStores(appDB *db.GoLevelDB) ([]OrphanStore, error) {
+	versions, err := rootmulti.CommitInfoVersions(appDB)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("application.db has no commit info")
+	}
+	appStore := rootmulti.NewStore(appDB, log.NewNopLogger())
+	referenced := make(map[string]bool)
+	for _, version := range versions {
+		cInfo, err := appStore.GetCommitInfo(version)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range cInfo.StoreInfos {
+			referenced[info.Name] = true
+		}
+	}
+
+	onDisk, err := rootmulti.StoreNamesOnDisk(appDB)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []OrphanStore
+	for _, name := range onDisk {
+		if referenced[name] {
+			continue
+		}
+		size, err := diskusage.PrefixSize(appDB, rootmulti.StorePrefix(name))
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, OrphanStore{Name: name, Size: size})
+	}
+	return orphans, nil
+}
+
+// deletePrefix deletes every key under prefix in batches and returns the
+// number of keys deleted, stopping between batches when ctx is cancelled.
+func deletePrefix(ctx context.Context, database db.DB, prefix []byte) (int, error) {
+	itr, err := db.IteratePrefix(database, prefix)
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	batch := database.NewBatch()
+	defer func() { batch.Close() }()
+	var deleted, pending int
+	for ; itr.Valid(); itr.Next() {
+		if err := batch.Delete(itr.Key()); err != nil {
+			return deleted, err
+		}
+		deleted++
+		if pending++; pending == deleteBatchKeys {
+			if err := batch.Write(); err != nil {
+				return deleted, err
+			}
+			batch.Close()
+			batch, pending = database.NewBatch(), 0
+			if err := ctx.Err(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return deleted, err
+	}
+	return deleted, batch.Write()
+}
@@ -0,0 +1,42 @@
+package pruner
+
+import (
+	"context"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+func TestOrphanStores(t *testing.T) {
+	dir := t.TempDir()
+	// gone is removed by an upgrade at version 4
+	saveTestAppState(t, dir, 3, "bank", "gone")
+	saveTestAppState(t, dir, 2, "bank")
+	p := newTestPruner(t, Options{DataDir: dir})
+
+	orphans, err := p.OrphanStores()
+	require.NoError(t, err)
+	require.Empty(t, orphans)
+
+	// once the versions from before the upgrade are pruned, its data is orphaned
+	appDB, err := db.NewGoLevelDB("application", dir)
+	require.NoError(t, err)
+	_, _, err = rootmulti.DeleteCommitInfosTo(appDB, 3)
+	require.NoError(t, err)
+	require.NoError(t, appDB.Close())
+
+	orphans, err = p.DeleteOrphanStores(context.Background())
+	require.NoError(t, err)
+	require.Len(t, orphans, 1)
+	require.Equal(t, "gone", orphans[0].Name)
+
+	appDB, err = db.NewGoLevelDB("application", dir)
+	require.NoError(t, err)
+	defer appDB.Close()
+	names, err := rootmulti.StoreNamesOnDisk(appDB)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bank"}, names)
+}
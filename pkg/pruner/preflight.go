@@ -0,0 +1,269 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	db "github.com/cometbft/cometbft-db"
+	tmstore "github.com/cometbft/cometbft/store"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/diskusage"
+	"github.com/binaryholdings/cosmos-pruner/internal/legacyiavl"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// deletionOverhead is the share of the deleted bytes LevelDB writes again as
+// tombstones and logs while pruning, before compaction drops them.
+const deletionOverhead = 0.05
+
+// DBEstimate is the estimated disk usage of pruning and compacting one database.
+type DBEstimate struct {
+	Name string
+	// CopyForward is set when the database is pruned by copying the retained
+	// data into a fresh database.
+	CopyForward bool
+	// Size is the current size of the database on disk.
+	Size int64
+	// PruneFraction is the share of the database pruning deletes.
+	PruneFraction float64
+	// PruneBytes is the extra space taken while pruning.
+	PruneBytes int64
+	// CompactBytes is the extra space a whole database compaction needs: the
+	// retained data is rewritten before the old tables are removed.
+	CompactBytes int64
+	// ChunkedCompactBytes is the extra space needed when compacting in chunks,
+	// which is bounded by the largest chunk.
+	ChunkedCompactBytes int64
+}
+
+// Plan is the outcome of the disk space preflight of a prune run.
+type Plan struct {
+	// Free is the free disk space of the data directory.
+	Free int64
+	DBs  []DBEstimate
+}
+
+// Required returns the peak extra disk space of pruning and compacting every
+// database, as the databases are pruned concurrently.
+func (plan *Plan) Required(chunked bool) int64 {
+	var required int64
+	for _, e := range plan.DBs {
+		required += e.PruneBytes
+		if chunked {
+			required += e.ChunkedCompactBytes
+		} else {
+			required += e.CompactBytes
+		}
+	}
+	return required
+}
+
+// Check returns an error when pruning and compacting do not fit on disk while
+// keeping minFree bytes free, and whether they only fit when compacting in
+// chunks.
+func (plan *Plan) Check(minFree int64) (chunked bool, err error) {
+	switch {
+	case plan.Free-plan.Required(false) >= minFree:
+		return false, nil
+	case plan.Free-plan.Required(true) >= minFree:
+		return true, nil
+	}
+	return false, fmt.Errorf("not enough free disk space: %d bytes free, %d bytes required with chunked compaction and %d bytes to keep free",
+		plan.Free, plan.Required(true), minFree)
+}
+
+func (p *Pruner) logPlan(plan *Plan) {
+	for _, e := range plan.DBs {
+		p.logger.Info("preflight", "db", e.Name, "copy_forward", e.CopyForward, "size", e.Size, "prune_fraction", fmt.Sprintf("%.2f", e.PruneFraction),
+			"prune_peak", e.PruneBytes, "compact_peak", e.CompactBytes, "chunked_compact_peak", e.ChunkedCompactBytes)
+	}
+	p.logger.Info("preflight", "free", plan.Free, "required", plan.Required(false), "required_chunked", plan.Required(true), "min_free_space", p.opts.MinFreeSpace)
+}
+
+// Plan estimates and logs the disk usage of pruning the data directory
+// without touching it, leaving out the parts Options.SkipBlocks and
+// Options.SkipApp skip.
+func (p *Pruner) Plan(ctx context.Context) (*Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	plan, err := p.plan(!p.opts.SkipBlocks, !p.opts.SkipApp)
+	if err != nil {
+		return nil, err
+	}
+	p.logPlan(plan)
+	return plan, nil
+}
+
+// plan estimates the disk usage of pruning the blocks, the application state
+// or both.
+func (p *Pruner) plan(blocks, app bool) (*Plan, error) {
+	dbDir := p.opts.DataDir
+
+	free, err := diskusage.Free(dbDir)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Free: free}
+
+	if blocks {
+		names := []string{"blockstore", "state", "tx_index"}
+		for _, extra := range appProfiles[p.opts.App].dbs {
+			names = append(names, extra.name)
+		}
+		for _, name := range names {
+			e, err := p.estimateDB(dbDir, name, prunedPrefixes[name], p.blockstorePruneFraction)
+			if err != nil {
+				return nil, err
+			}
+			plan.DBs = append(plan.DBs, e)
+		}
+	}
+
+	if app {
+		e, err := p.estimateDB(dbDir, "application", nil, p.applicationPruneFraction)
+		if err != nil {
+			return nil, err
+		}
+		plan.DBs = append(plan.DBs, e)
+
+		dbNames, err := p.profileStoreDBs()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range dbNames {
+			e, err := p.estimateDB(dbDir, name, [][]byte{separateStorePrefix}, p.applicationPruneFraction)
+			if err != nil {
+				return nil, err
+			}
+			plan.DBs = append(plan.DBs, e)
+		}
+	}
+
+	return plan, nil
+}
+
+// estimateDB opens a database read only and estimates the disk usage of
+// pruning it. pruneFraction returns the share of the database to be deleted.
+func (p *Pruner) estimateDB(dbDir, name string, prefixes [][]byte, pruneFraction func(dbDir string) (float64, error)) (DBEstimate, error) {
+	e := DBEstimate{Name: name}
+	if _, err := os.Stat(filepath.Join(dbDir, name+".db")); os.IsNotExist(err) {
+		return e, nil
+	}
+
+	size, err := diskusage.DirSize(filepath.Join(dbDir, name+".db"))
+	if err != nil {
+		return e, err
+	}
+	e.Size = size
+
+	if e.PruneFraction, err = pruneFraction(dbDir); err != nil {
+		return e, err
+	}
+
+	database, err := p.openDB(name, dbDir, true)
+	if err != nil {
+		return e, err
+	}
+	defer database.Close()
+
+	if name == "application" {
+		if prefixes, err = appStorePrefixes(database); err != nil {
+			return e, err
+		}
+	}
+
+	var largest int64
+	for _, r := range compactionChunks(prefixes) {
+		sizes, err := database.DB().SizeOf([]util.Range{r})
+		if err != nil {
+			return e, err
+		}
+		if sizes.Sum() > largest {
+			largest = sizes.Sum()
+		}
+	}
+
+	retained := 1 - e.PruneFraction
+	if (name == "blockstore" || name == "state") && e.PruneFraction > 0 && p.useCopyForward(retained) {
+		// the copy holds the retained data and needs no compaction
+		e.CopyForward = true
+		e.PruneBytes = int64(float64(size) * retained)
+		return e, nil
+	}
+	e.PruneBytes = int64(float64(size) * e.PruneFraction * deletionOverhead)
+	e.CompactBytes = int64(float64(size) * retained)
+	e.ChunkedCompactBytes = int64(float64(largest) * retained)
+	return e, nil
+}
+
+// blockstorePruneFraction returns the share of heights pruning removes from
+// the block store, which the state store and tx index follow as well.
+func (p *Pruner) blockstorePruneFraction(dbDir string) (float64, error) {
+	if _, err := os.Stat(filepath.Join(dbDir, "blockstore.db")); os.IsNotExist(err) {
+		return 0, nil
+	}
+	blockStoreDB, err := p.openDB("blockstore", dbDir, true)
+	if err != nil {
+		return 0, err
+	}
+	defer blockStoreDB.Close()
+
+	blockStore := tmstore.NewBlockStore(blockStoreDB)
+	return pruneFraction(blockStore.Base(), blockStore.Height(), blockStore.Height()-int64(p.opts.KeepBlocks)), nil
+}
+
+// applicationPruneFraction returns the share of versions pruning removes from
+// the application store.
+func (p *Pruner) applicationPruneFraction(dbDir string) (float64, error) {
+	appDB, err := p.openDB("application", dbDir, true)
+	if err != nil {
+		return 0, err
+	}
+	defer appDB.Close()
+
+	latest := rootmulti.GetLatestVersion(appDB)
+	if latest <= 0 {
+		return 0, nil
+	}
+	earliest, err := earliestVersion(appDB)
+	if err != nil {
+		return 0, err
+	}
+	return pruneFraction(earliest, latest, latest-int64(p.opts.KeepVersions)), nil
+}
+
+// earliestVersion returns the lowest version a store of the latest commit
+// info has an IAVL root for, or 0. Commit infos are no guide, they may
+// outlive the versions they describe.
+func earliestVersion(appDB db.DB) (int64, error) {
+	names, err := rootmulti.LatestStoreNames(appDB)
+	if err != nil {
+		return 0, err
+	}
+
+	var earliest int64
+	for _, name := range names {
+		info, err := legacyiavl.Inspect(db.NewPrefixDB(appDB, rootmulti.StorePrefix(name)))
+		if err != nil {
+			return 0, fmt.Errorf("store %s: %w", name, err)
+		}
+		if version := info.EarliestVersion(); version > 0 && (earliest == 0 || version < earliest) {
+			earliest = version
+		}
+	}
+	return earliest, nil
+}
+
+func pruneFraction(base, height, pruneHeight int64) float64 {
+	if height <= base || pruneHeight <= base {
+		return 0
+	}
+	if pruneHeight > height {
+		pruneHeight = height
+	}
+	return float64(pruneHeight-base) / float64(height-base+1)
+}
@@ -0,0 +1,46 @@
+package pruner
+
+import (
+	"context"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	"github.com/cosmos/cosmos-sdk/store/iavl"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+func TestApplicationPruneFraction(t *testing.T) {
+	dir := t.TempDir()
+	saveTestStates(t, dir, 40, 13)
+	saveTestAppState(t, dir, 40, "acc", "bank")
+
+	// versions pruned by the node leave their commit infos behind
+	appDB, err := db.NewGoLevelDB("application", dir)
+	require.NoError(t, err)
+	for _, name := range []string{"acc", "bank"} {
+		store, err := iavl.LoadStore(db.NewPrefixDB(appDB, rootmulti.StorePrefix(name)), log.NewNopLogger(),
+			storetypes.NewKVStoreKey(name), storetypes.CommitID{}, 0, true)
+		require.NoError(t, err)
+		require.NoError(t, store.(*iavl.Store).DeleteVersionsTo(20))
+	}
+	earliest, err := earliestVersion(appDB)
+	require.NoError(t, err)
+	require.Equal(t, int64(21), earliest)
+	require.NoError(t, appDB.Close())
+
+	p := newTestPruner(t, Options{DataDir: dir, App: "osmosis", KeepVersions: 10})
+	fraction, err := p.applicationPruneFraction(dir)
+	require.NoError(t, err)
+	require.Equal(t, pruneFraction(21, 40, 30), fraction)
+
+	// the state store is estimated without a block store
+	plan, err := p.Plan(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "state", plan.DBs[1].Name)
+	require.Positive(t, plan.DBs[1].Size)
+	require.Zero(t, plan.DBs[1].PruneFraction)
+}
@@ -0,0 +1,173 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	db "github.com/cometbft/cometbft-db"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// appProfile describes what a family of apps keeps on top of the core SDK
+// stores: more stores in application.db, and databases of their own next to
+// it that are pruned along with the blocks. storeDBs maps the stores kept
+// out of application.db to the database holding them, e.g. wasm to wasm.db.
+type appProfile struct {
+	stores   []string
+	storeDBs map[string]string
+	dbs      []extraDB
+}
+
+// extraDB is a database an app keeps in the data directory. prune deletes
+// the data below pruneHeight and returns the number of keys deleted.
+type extraDB struct {
+	name  string
+	prune func(ctx context.Context, database db.DB, pruneHeight int64) (int, error)
+}
+
+// appProfiles are the apps Options.App supports. Stores a chain of the family
+// does not have are not mounted, so one profile covers the whole family.
+var appProfiles = map[string]appProfile{
+	"osmosis": {
+		stores: []string{
+			"downtimedetector",
+			"hooks-for-ibc",
+			"lockup", //lockuptypes.StoreKey,
+			"concentratedliquidity",
+			"gamm", // gammtypes.StoreKey,
+			"cosmwasmpool",
+			"poolmanager",
+			"twap",
+			"epochs", // epochstypes.StoreKey,
+			"protorev",
+			"txfees",         // txfeestypes.StoreKey,
+			"incentives",     // incentivestypes.StoreKey,
+			"poolincentives", //poolincentivestypes.StoreKey,
+			"tokenfactory",   //tokenfactorytypes.StoreKey,
+			"valsetpref",
+			"superfluid", // superfluidtypes.StoreKey,
+			"wasm",       // wasm.StoreKey,
+			//"rate-limited-ibc", // there is no store registered for this module
+		},
+	},
+	// Ethermint based chains: Evmos, Cronos, Kava, Canto
+	"evm": {
+		stores: []string{
+			"evm", "feemarket", "erc20", "feegrant",
+			// evmos
+			"inflation", "vesting", "epochs", "revenue", "recovery", "claims", "incentives",
+			// cronos
+			"cronos", "gravity",
+			// kava
+			"evmutil", "kavadist", "incentive", "hard", "cdp", "pricefeed", "swap", "savings", "liquid", "earn", "router", "community",
+			// canto
+			"csr", "coinswap", "govshuttle", "onboarding",
+		},
+		dbs: []extraDB{
+			{name: "evmindexer", prune: pruneEVMIndexer},
+		},
+	},
+}
+
+// Apps returns the names of the app profiles Options.App supports.
+func Apps() []string {
+	names := make([]string, 0, len(appProfiles))
+	for name := range appProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profileStoreDBs returns the databases of the stores the app keeps out of
+// application.db, by store name: the ones of the profile and of
+// Options.StoreDBs, which wins. Database names have no .db suffix.
+func (p *Pruner) profileStoreDBs() (map[string]string, error) {
+	dbs := make(map[string]string)
+	for store, name := range appProfiles[p.opts.App].storeDBs {
+		dbs[store] = name
+	}
+	for store, name := range p.opts.StoreDBs {
+		dbs[store] = strings.TrimSuffix(name, ".db")
+	}
+
+	stores := make(map[string]string, len(dbs))
+	for store, name := range dbs {
+		if name == "application" {
+			return nil, fmt.Errorf("store %s: application.db holds the stores without a database of their own", store)
+		}
+		if other, ok := stores[name]; ok {
+			return nil, fmt.Errorf("stores %s and %s cannot share %s.db", other, store, name)
+		}
+		stores[name] = store
+	}
+	return dbs, nil
+}
+
+// openStoreDBs opens the databases of the stores among keys that are kept
+// out of application.db. The caller closes them.
+func (p *Pruner) openStoreDBs(dbDir string, keys map[string]*storetypes.KVStoreKey, readOnly bool) (map[string]*db.GoLevelDB, error) {
+	names, err := p.profileStoreDBs()
+	if err != nil {
+		return nil, err
+	}
+
+	opened := make(map[string]*db.GoLevelDB)
+	for store, name := range names {
+		if _, ok := keys[store]; !ok {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dbDir, name+".db")); err != nil {
+			closeStoreDBs(opened)
+			return nil, fmt.Errorf("store %s: %w", store, err)
+		}
+		database, err := p.openDB(name, dbDir, readOnly)
+		if err != nil {
+			closeStoreDBs(opened)
+			return nil, fmt.Errorf("store %s: %w", store, err)
+		}
+		opened[store] = database
+	}
+	return opened, nil
+}
+
+func closeStoreDBs(dbs map[string]*db.GoLevelDB) {
+	for _, database := range dbs {
+		database.Close()
+	}
+}
+
+// committedStoreKeys returns the keys of the stores the app has committed
+// in its latest version, and logs the committed stores that have no key and
+// so are left unpruned.
+func (p *Pruner) committedStoreKeys(appDB db.DB, keys map[string]*storetypes.KVStoreKey) (map[string]*storetypes.KVStoreKey, error) {
+	names, err := rootmulti.LatestStoreNames(appDB)
+	if err != nil {
+		return nil, err
+	}
+
+	committed := make(map[string]*storetypes.KVStoreKey, len(names))
+	for _, name := range names {
+		key, ok := keys[name]
+		if !ok {
+			p.logger.Info("store is not part of the app profile, not pruning it", "store", name, "app", p.opts.App)
+			continue
+		}
+		committed[name] = key
+	}
+	for name := range keys {
+		if _, ok := committed[name]; !ok {
+			p.logger.Debug("store was not committed, not mounting it", "store", name)
+		}
+	}
+	if len(committed) == 0 {
+		return nil, fmt.Errorf("none of the stores of app %q are in the database", p.opts.App)
+	}
+	return committed, nil
+}
@@ -0,0 +1,105 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// evmStorageKey returns the key of a contract storage slot in the evm store:
+// 0x02, the contract address and the slot.
+func evmStorageKey(contract, slot int) []byte {
+	key := []byte{0x02}
+	key = append(key, bytes.Repeat([]byte{byte(contract)}, 20)...)
+	return append(key, bytes.Repeat([]byte{byte(slot)}, 32)...)
+}
+
+func v1RootKey(store string, version int64) []byte {
+	key := []byte("s/k:" + store + "/s")
+	key = binary.BigEndian.AppendUint64(key, uint64(version))
+	return binary.BigEndian.AppendUint32(key, 1)
+}
+
+func TestPruneEVMAppState(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestPruner(t, Options{DataDir: dir, App: "evm", KeepVersions: 5})
+
+	// "mystery" is a store the profile does not know
+	stores := []string{"acc", "bank", "evm", "feemarket", "erc20", "mystery"}
+	saveTestAppStateWith(t, dir, 20, nil, func(appStore *rootmulti.Store, v int64) {
+		// contract code, and storage slots rewritten every version
+		evm := appStore.GetKVStore(appStore.StoreKeysByName()["evm"])
+		evm.Set(append([]byte{0x01}, bytes.Repeat([]byte{byte(v)}, 32)...), []byte("code"))
+		for contract := 0; contract < 3; contract++ {
+			for slot := 0; slot < 10; slot++ {
+				evm.Set(evmStorageKey(contract, slot), []byte(fmt.Sprintf("%d-%d-%d", v, contract, slot)))
+			}
+		}
+	}, stores...)
+
+	result := &AppResult{}
+	require.NoError(t, p.pruneAppState(context.Background(), NodeVersion037, result))
+	require.Equal(t, &AppResult{LatestVersion: 20, PruneVersion: 15}, result)
+
+	appDB, err := db.NewGoLevelDB("application", dir)
+	require.NoError(t, err)
+	defer appDB.Close()
+	for _, name := range stores {
+		for v := int64(1); v <= 20; v++ {
+			has, err := appDB.Has(v1RootKey(name, v))
+			require.NoError(t, err)
+			// stores outside of the profile are left alone
+			require.Equal(t, v > 15 || name == "mystery", has, "store %s version %d", name, v)
+		}
+	}
+
+	pruned, err := rootmulti.LoadStoresAt(appDB, log.NewNopLogger(), nil, 16, stores...)
+	require.NoError(t, err)
+	evm := pruned.GetKVStore(pruned.StoreKeysByName()["evm"])
+	for contract := 0; contract < 3; contract++ {
+		for slot := 0; slot < 10; slot++ {
+			require.Equal(t, []byte(fmt.Sprintf("16-%d-%d", contract, slot)), evm.Get(evmStorageKey(contract, slot)))
+		}
+	}
+	for v := 1; v <= 16; v++ {
+		require.True(t, evm.Has(append([]byte{0x01}, bytes.Repeat([]byte{byte(v)}, 32)...)), "code of version %d", v)
+	}
+}
+
+func TestPruneSeparateStoreDB(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestPruner(t, Options{DataDir: dir, App: "none", KeepVersions: 5, StoreDBs: map[string]string{"wasm": "wasm.db"}})
+
+	wasmDB, err := db.NewGoLevelDB("wasm", dir)
+	require.NoError(t, err)
+	saveTestAppStateWith(t, dir, 20, map[string]db.DB{"wasm": wasmDB}, nil, "bank", "wasm")
+	require.NoError(t, wasmDB.Close())
+
+	require.NoError(t, p.pruneAppState(context.Background(), NodeVersion037, &AppResult{}))
+
+	appDB, err := db.NewGoLevelDB("application", dir)
+	require.NoError(t, err)
+	defer appDB.Close()
+	wasmDB, err = db.NewGoLevelDB("wasm", dir)
+	require.NoError(t, err)
+	defer wasmDB.Close()
+	require.Zero(t, countPrefix(t, appDB, "s/k:wasm/"))
+	for v := int64(1); v <= 20; v++ {
+		root := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint64([]byte("s/_/s"), uint64(v)), 1)
+		has, err := wasmDB.Has(root)
+		require.NoError(t, err)
+		require.Equal(t, v > 15, has, "version %d", v)
+	}
+
+	pruned, err := rootmulti.LoadStoresAt(appDB, log.NewNopLogger(), map[string]db.DB{"wasm": wasmDB}, 16, "bank", "wasm")
+	require.NoError(t, err)
+	require.Equal(t, []byte("16"), pruned.GetKVStore(pruned.StoreKeysByName()["wasm"]).Get([]byte("height")))
+}
@@ -0,0 +1,326 @@
+// Package pruner prunes the data directory of a stopped Cosmos SDK node: the
+// CometBFT block store, state store and tx index, and the application state.
+// It is what the cosmprund command runs, for embedding in other tools.
+//
+//	p, err := pruner.New(pruner.Options{
+//		DataDir:      "/root/.osmosisd/data",
+//		App:          "osmosis",
+//		KeepBlocks:   100,
+//		KeepVersions: 100,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	report, err := p.Run(ctx)
+//
+// The node must not be running while its data directory is pruned.
+package pruner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/throttle"
+)
+
+// Strategy is how the block and state stores are pruned.
+type Strategy string
+
+const (
+	// StrategyAuto copies the retained heights forward when at most
+	// Options.CopyThreshold of a store is retained, and deletes otherwise.
+	StrategyAuto Strategy = "auto"
+	// StrategyDelete deletes the pruned heights in place.
+	StrategyDelete Strategy = "delete"
+	// StrategyCopy copies the retained heights into a fresh database.
+	StrategyCopy Strategy = "copy"
+)
+
+// defaultCopyThreshold is the Options.CopyThreshold used when it is not set.
+const defaultCopyThreshold = 0.1
+
+// Options configure a Pruner. DataDir, and KeepBlocks and KeepVersions unless
+// SkipBlocks and SkipApp leave their part out, must be set; the zero value of
+// every other field is usable.
+type Options struct {
+	// DataDir is the data directory of the node, holding application.db,
+	// blockstore.db, state.db and tx_index.db.
+	DataDir string
+	// App is the app profile deciding the application stores to prune, see
+	// Apps. Unknown apps get the core SDK stores only.
+	App string
+	// KeepBlocks is the number of blocks to keep in the block store, along
+	// with their states and indexed txs. New rejects zero, which would prune
+	// all but the latest block, unless SkipBlocks is set.
+	KeepBlocks uint64
+	// KeepVersions is the number of versions to keep in the application
+	// state. New rejects zero unless SkipApp is set.
+	KeepVersions uint64
+	// SkipBlocks leaves the block store, state store and tx index alone in Run
+	// and Plan.
+	SkipBlocks bool
+	// SkipApp leaves the application state alone in Run and Plan.
+	SkipApp bool
+
+	// Strategy is how the block and state stores are pruned, StrategyAuto if
+	// empty.
+	Strategy Strategy
+	// CopyThreshold is the largest retained share of a store StrategyAuto
+	// copies forward, 0.1 if zero.
+	CopyThreshold float64
+	// MinFreeSpace is the free disk space in bytes to keep while pruning and
+	// compacting.
+	MinFreeSpace int64
+	// IORateLimit is the write and compaction budget per second, in bytes
+	// (e.g. 64MiB) or ops (e.g. 2000ops). Empty is unlimited.
+	IORateLimit string
+	// LevelDBOptions returns the options to open the named database with,
+	// DefaultLevelDBOptions if nil.
+	LevelDBOptions func(name string, readOnly bool) (*opt.Options, error)
+
+	// Concurrency is the number of application stores loaded and pruned at
+	// once, all of them if zero.
+	Concurrency int
+	// IAVLCacheSize is the IAVL node cache size per store. If zero it is
+	// derived from MemoryBudget.
+	IAVLCacheSize int
+	// MemoryBudget is the memory in bytes the IAVL caches of all stores may
+	// use, half of the available memory if zero.
+	MemoryBudget int64
+	// KeepFastNodes keeps the IAVL fast nodes up to date while pruning, which
+	// is slower. FastNodeWhitelist limits it to the listed stores.
+	KeepFastNodes     bool
+	FastNodeWhitelist []string
+	// MigrateLegacy migrates the stores written by IAVL v0.19/v0.20 to the
+	// IAVL v1 layout after pruning them.
+	MigrateLegacy bool
+	// StoreDBs maps the stores kept in a database of their own to its name,
+	// e.g. wasm to wasm.db, on top of the ones of the app profile.
+	StoreDBs map[string]string
+	// MemIAVLKeepRecent is the number of memiavl snapshots to keep besides
+	// the one in use.
+	MemIAVLKeepRecent int
+
+	// Wasm cleans up the wasm directory after pruning the application state.
+	Wasm bool
+	// WasmDir is the wasm directory of the node, found next to DataDir if
+	// empty.
+	WasmDir string
+
+	// Logger receives the logs of the pruner, which logs nothing if nil.
+	Logger log.Logger
+	// Progress is called whenever a phase of a run changes status.
+	Progress func(Progress)
+}
+
+// Progress is a status change of a phase of a run.
+type Progress struct {
+	Phase  string
+	Status PhaseStatus
+	// Elapsed is the time the phase ran for, once it finished.
+	Elapsed time.Duration
+	// Err is why the phase failed or was skipped.
+	Err error
+}
+
+// Report is the outcome of a run: the status of every phase and the results
+// of the ones that ran.
+type Report struct {
+	NodeVersion NodeVersion
+	Phases      []*Phase
+	Blocks      *BlocksResult
+	App         *AppResult
+}
+
+// WriteFile writes the phases of the report to path as JSON.
+func (r *Report) WriteFile(path string) error {
+	bz, err := json.MarshalIndent(r.Phases, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bz, '\n'), 0o644)
+}
+
+// Modified reports whether any phase started, and so may have changed the
+// databases. A run that failed before that left them as they were.
+func (r *Report) Modified() bool {
+	for _, phase := range r.Phases {
+		if phase.Status != PhasePending && phase.Status != PhaseSkipped {
+			return true
+		}
+	}
+	return false
+}
+
+// Pruner prunes a data directory. Its methods must not run concurrently.
+type Pruner struct {
+	opts      Options
+	logger    log.Logger
+	ioLimiter *throttle.Limiter
+
+	// chunkedCompaction is set when the preflight finds too little free space
+	// to compact whole databases
+	chunkedCompaction bool
+}
+
+// New returns a Pruner for the data directory and options of opts.
+func New(opts Options) (*Pruner, error) {
+	if opts.DataDir == "" {
+		return nil, fmt.Errorf("no data directory")
+	}
+	if opts.KeepBlocks == 0 && !opts.SkipBlocks {
+		return nil, fmt.Errorf("no blocks to keep, set KeepBlocks or SkipBlocks")
+	}
+	if opts.KeepVersions == 0 && !opts.SkipApp {
+		return nil, fmt.Errorf("no versions to keep, set KeepVersions or SkipApp")
+	}
+	switch opts.Strategy {
+	case "":
+		opts.Strategy = StrategyAuto
+	case StrategyAuto, StrategyDelete, StrategyCopy:
+	default:
+		return nil, fmt.Errorf("unknown prune strategy %q (supported: auto, delete, copy)", opts.Strategy)
+	}
+	if opts.CopyThreshold == 0 {
+		opts.CopyThreshold = defaultCopyThreshold
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.NewNopLogger()
+	}
+
+	ioLimiter, err := throttle.ParseLimiter(opts.IORateLimit)
+	if err != nil {
+		return nil, err
+	}
+	return &Pruner{opts: opts, logger: opts.Logger, ioLimiter: ioLimiter}, nil
+}
+
+// Run prunes the data directory: the block store, state store, tx index and
+// the databases of the app profile, and the application state, concurrently
+// where they do not depend on each other. It checks first that pruning and
+// compacting fit on disk. The report holds the phases that ran, even when Run
+// fails.
+func (p *Pruner) Run(ctx context.Context) (*Report, error) {
+	report := &Report{}
+	version, err := p.prepare(ctx, !p.opts.SkipBlocks, !p.opts.SkipApp)
+	if err != nil {
+		return report, err
+	}
+	report.NodeVersion = version
+
+	o := newOrchestrator(p.logger, p.opts.Progress)
+	if !p.opts.SkipBlocks {
+		if report.Blocks, err = p.addBlockPhases(o, version); err != nil {
+			return report, err
+		}
+	}
+	if !p.opts.SkipApp {
+		report.App = p.addAppPhases(o, version)
+	} else if p.opts.Wasm {
+		o.add("wasm", p.pruneWasmDir)
+	}
+	err = o.Run(ctx)
+	report.Phases = o.phases
+	o.log()
+	return report, err
+}
+
+// PruneBlocks prunes the block store, the state store, the tx index and the
+// databases the app profile keeps next to them.
+func (p *Pruner) PruneBlocks(ctx context.Context) (*BlocksResult, error) {
+	version, err := p.prepare(ctx, true, false)
+	if err != nil {
+		return nil, err
+	}
+	o := newOrchestrator(p.logger, p.opts.Progress)
+	result, err := p.addBlockPhases(o, version)
+	if err != nil {
+		return nil, err
+	}
+	err = o.Run(ctx)
+	o.log()
+	return result, err
+}
+
+// PruneApp prunes the application state, and the wasm directory with
+// Options.Wasm.
+func (p *Pruner) PruneApp(ctx context.Context) (*AppResult, error) {
+	version, err := p.prepare(ctx, false, true)
+	if err != nil {
+		return nil, err
+	}
+	o := newOrchestrator(p.logger, p.opts.Progress)
+	result := p.addAppPhases(o, version)
+	err = o.Run(ctx)
+	o.log()
+	return result, err
+}
+
+// prepare gets the data directory ready to prune the blocks, the application
+// state or both: it finishes swapping in databases a previous run copied,
+// checks that pruning and compacting fit on disk and detects the node version.
+func (p *Pruner) prepare(ctx context.Context, blocks, app bool) (NodeVersion, error) {
+	if blocks {
+		for _, name := range []string{"blockstore", "state"} {
+			if err := p.recoverSwap(p.opts.DataDir, name); err != nil {
+				return NodeVersionUnknown, err
+			}
+		}
+	}
+
+	plan, err := p.plan(blocks, app)
+	if err != nil {
+		return NodeVersionUnknown, err
+	}
+	p.logPlan(plan)
+	if p.chunkedCompaction, err = plan.Check(p.opts.MinFreeSpace); err != nil {
+		return NodeVersionUnknown, err
+	}
+	if p.chunkedCompaction {
+		p.logger.Info("not enough free space for whole database compaction, compacting in chunks")
+	}
+	if err := ctx.Err(); err != nil {
+		return NodeVersionUnknown, err
+	}
+
+	version, err := p.detectNodeVersion(p.opts.DataDir)
+	if err != nil {
+		return NodeVersionUnknown, err
+	}
+	p.logger.Info("detected node version", "version", version)
+	p.reportVersionDB(p.opts.DataDir)
+	return version, nil
+}
+
+// openDB opens the named database in dir with the options of
+// Options.LevelDBOptions.
+func (p *Pruner) openDB(name, dir string, readOnly bool) (*db.GoLevelDB, error) {
+	o, err := p.levelDBOptions(name, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewGoLevelDBWithOpts(name, dir, o)
+}
+
+// DefaultLevelDBOptions returns the options databases are opened with when
+// Options.LevelDBOptions is nil: the goleveldb defaults without seek triggered
+// compactions, which a pruner iterating whole stores would set off on every
+// table.
+func DefaultLevelDBOptions(readOnly bool) *opt.Options {
+	return &opt.Options{DisableSeeksCompaction: true, ReadOnly: readOnly}
+}
+
+// levelDBOptions returns the options of the named database.
+func (p *Pruner) levelDBOptions(name string, readOnly bool) (*opt.Options, error) {
+	if p.opts.LevelDBOptions == nil {
+		return DefaultLevelDBOptions(readOnly), nil
+	}
+	return p.opts.LevelDBOptions(name, readOnly)
+}
@@ -0,0 +1,162 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// newTestPruner returns a Pruner for opts with a small IAVL cache, keeping 10
+// blocks and versions unless opts sets them.
+func newTestPruner(t *testing.T, opts Options) *Pruner {
+	if opts.IAVLCacheSize == 0 {
+		opts.IAVLCacheSize = 1000
+	}
+	if opts.KeepBlocks == 0 {
+		opts.KeepBlocks = 10
+	}
+	if opts.KeepVersions == 0 {
+		opts.KeepVersions = 10
+	}
+	p, err := New(opts)
+	require.NoError(t, err)
+	return p
+}
+
+func countPrefix(t *testing.T, database db.DB, prefix string) int {
+	itr, err := db.IteratePrefix(database, []byte(prefix))
+	require.NoError(t, err)
+	defer itr.Close()
+	n := 0
+	for ; itr.Valid(); itr.Next() {
+		n++
+	}
+	return n
+}
+
+// saveTestAppState commits n versions of the named stores to the
+// application.db in dir, setting "height" in every store.
+func saveTestAppState(t *testing.T, dir string, n int64, stores ...string) {
+	saveTestAppStateWith(t, dir, n, nil, nil, stores...)
+}
+
+// saveTestAppStateWith is saveTestAppState with the stores in storeDBs kept in
+// databases of their own, and write, when set, called before each version is
+// committed.
+func saveTestAppStateWith(t *testing.T, dir string, n int64, storeDBs map[string]db.DB, write func(appStore *rootmulti.Store, v int64), stores ...string) {
+	appDB, err := db.NewGoLevelDB("application", dir)
+	require.NoError(t, err)
+	defer appDB.Close()
+	appStore := rootmulti.NewStore(appDB, log.NewNopLogger())
+	keys := make(map[string]*storetypes.KVStoreKey)
+	for _, name := range stores {
+		keys[name] = storetypes.NewKVStoreKey(name)
+		appStore.MountStoreWithDB(keys[name], storetypes.StoreTypeIAVL, storeDBs[name])
+	}
+	require.NoError(t, appStore.LoadLatestVersion())
+	for v := int64(1); v <= n; v++ {
+		appStore.SetCommitHeader(cmtproto.Header{Height: v})
+		for _, key := range keys {
+			appStore.GetKVStore(key).Set([]byte("height"), []byte(fmt.Sprint(v)))
+		}
+		if write != nil {
+			write(appStore, v)
+		}
+		appStore.Commit()
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	valid := Options{DataDir: t.TempDir(), KeepBlocks: 10, KeepVersions: 10}
+	for _, tc := range []struct {
+		change func(opts *Options)
+		err    string
+	}{
+		{func(opts *Options) { opts.DataDir = "" }, "data directory"},
+		{func(opts *Options) { opts.Strategy = "move" }, "strategy"},
+		{func(opts *Options) { opts.IORateLimit = "fast" }, "io rate limit"},
+		// keeping nothing has to be asked for
+		{func(opts *Options) { opts.KeepBlocks = 0 }, "KeepBlocks"},
+		{func(opts *Options) { opts.KeepVersions = 0 }, "KeepVersions"},
+	} {
+		opts := valid
+		tc.change(&opts)
+		_, err := New(opts)
+		require.ErrorContains(t, err, tc.err)
+	}
+	_, err := New(Options{DataDir: t.TempDir(), SkipBlocks: true, SkipApp: true})
+	require.NoError(t, err)
+
+	p, err := New(valid)
+	require.NoError(t, err)
+	require.Equal(t, StrategyAuto, p.opts.Strategy)
+	require.Equal(t, defaultCopyThreshold, p.opts.CopyThreshold)
+}
+
+func TestLevelDBOptionsDefault(t *testing.T) {
+	p := newTestPruner(t, Options{DataDir: t.TempDir()})
+	o, err := p.levelDBOptions("application", true)
+	require.NoError(t, err)
+	// the same options as the default preset of the command
+	require.Equal(t, DefaultLevelDBOptions(true), o)
+	require.True(t, o.DisableSeeksCompaction)
+	require.True(t, o.ReadOnly)
+}
+
+func TestRunAndVerify(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	saveTestBlocks(t, dir, 40)
+	saveTestStates(t, dir, 40, 13)
+	saveTestAppState(t, dir, 40, "acc", "bank")
+
+	var (
+		mu       sync.Mutex
+		statuses = make(map[string][]PhaseStatus)
+	)
+	p := newTestPruner(t, Options{
+		DataDir:      dir,
+		App:          "osmosis",
+		KeepBlocks:   10,
+		KeepVersions: 10,
+		Progress: func(progress Progress) {
+			mu.Lock()
+			defer mu.Unlock()
+			statuses[progress.Phase] = append(statuses[progress.Phase], progress.Status)
+		},
+	})
+
+	plan, err := p.Plan(context.Background())
+	require.NoError(t, err)
+	require.Len(t, plan.DBs, 4)
+
+	report, err := p.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, NodeVersion037, report.NodeVersion)
+	require.Equal(t, &BlocksResult{Base: 1, Height: 40, PruneHeight: 30}, report.Blocks)
+	require.Equal(t, &AppResult{LatestVersion: 40, PruneVersion: 30}, report.App)
+	for _, phase := range report.Phases {
+		require.Equal(t, PhaseDone, phase.Status, phase.Name)
+		require.Equal(t, []PhaseStatus{PhaseRunning, PhaseDone}, statuses[phase.Name], phase.Name)
+	}
+
+	result, err := p.Verify(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &VerifyResult{
+		BlockBase:       30,
+		BlockHeight:     40,
+		StateHeight:     40,
+		EarliestVersion: 31,
+		LatestVersion:   40,
+		Stores:          2,
+	}, result)
+}
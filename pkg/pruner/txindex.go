@@ -0,0 +1,117 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/google/orderedcode"
+)
+
+// blockEventsPrefix is the prefix of the block indexer inside tx_index.db.
+var blockEventsPrefix = []byte("block_events")
+
+// txHeightPrefix is the prefix of the tx indexer's tx.height index, whose
+// values are the hashes of the primary tx result keys.
+var txHeightPrefix = []byte("tx.height/")
+
+// txIndexBatchSize is the number of deletes written per batch.
+const txIndexBatchSize = 10000
+
+// pruneTxIndex deletes the indexed txs and block events below pruneHeight from
+// the tx and block indexers sharing tx_index.db, returning the number of keys
+// deleted. It stops between batches when ctx is cancelled.
+func pruneTxIndex(ctx context.Context, txIndexDB db.DB, pruneHeight int64) (int, error) {
+	itr, err := txIndexDB.Iterator(nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	batch := txIndexDB.NewBatch()
+	defer func() { batch.Close() }()
+
+	var deleted, pending int
+	del := func(key []byte) error {
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+		deleted++
+		pending++
+		if pending < txIndexBatchSize {
+			return nil
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Close()
+		batch, pending = txIndexDB.NewBatch(), 0
+		return ctx.Err()
+	}
+
+	for ; itr.Valid(); itr.Next() {
+		key := itr.Key()
+		height, ok := txIndexKeyHeight(key)
+		if !ok || height >= pruneHeight {
+			continue
+		}
+		if err := del(key); err != nil {
+			return deleted, err
+		}
+		// the tx.height entry of a tx points at its primary tx result key
+		if bytes.HasPrefix(key, txHeightPrefix) {
+			if err := del(itr.Value()); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return deleted, err
+	}
+
+	if pending > 0 {
+		if err := batch.Write(); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// txIndexKeyHeight returns the height of a tx or block indexer key in
+// tx_index.db. Primary tx result keys, which are bare hashes, have none.
+func txIndexKeyHeight(key []byte) (int64, bool) {
+	if bytes.HasPrefix(key, blockEventsPrefix) {
+		return blockEventKeyHeight(key[len(blockEventsPrefix):])
+	}
+
+	// tx indexer keys are <composite key>/<value>/<height>/<index>[$es$<seq>]
+	if !utf8.Valid(key) || bytes.Count(key, []byte("/")) < 3 {
+		return 0, false
+	}
+	parts := strings.Split(string(key), "/")
+	height, err := strconv.ParseInt(parts[len(parts)-2], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return height, true
+}
+
+// blockEventKeyHeight returns the height of a block indexer key, which is
+// either (block.height, height) or (composite key, value, height, type, seq)
+// encoded with orderedcode.
+func blockEventKeyHeight(key []byte) (int64, bool) {
+	var (
+		compositeKey, eventValue string
+		height                   int64
+	)
+	if remaining, err := orderedcode.Parse(string(key), &compositeKey, &height); err == nil && len(remaining) == 0 {
+		return height, true
+	}
+	if _, err := orderedcode.Parse(string(key), &compositeKey, &eventValue, &height); err == nil {
+		return height, true
+	}
+	return 0, false
+}
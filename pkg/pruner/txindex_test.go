@@ -0,0 +1,55 @@
+package pruner
+
+import (
+	"context"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	abci "github.com/cometbft/cometbft/abci/types"
+	blockidxkv "github.com/cometbft/cometbft/state/indexer/block/kv"
+	"github.com/cometbft/cometbft/state/txindex/kv"
+	"github.com/cometbft/cometbft/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneTxIndex(t *testing.T) {
+	store := db.NewMemDB()
+	txIndexer := kv.NewTxIndex(store)
+	blockIndexer := blockidxkv.New(db.NewPrefixDB(store, blockEventsPrefix))
+
+	events := []abci.Event{{Type: "transfer", Attributes: []abci.EventAttribute{{Key: "sender", Value: "a/b/c", Index: true}}}}
+	var txs []types.Tx
+	for h := int64(1); h <= 10; h++ {
+		tx := types.Tx([]byte{byte(h)})
+		txs = append(txs, tx)
+		require.NoError(t, txIndexer.Index(&abci.TxResult{Height: h, Tx: tx, Result: abci.ResponseDeliverTx{Events: events}}))
+		require.NoError(t, blockIndexer.Index(types.EventDataNewBlockHeader{
+			Header:           types.Header{Height: h},
+			ResultBeginBlock: abci.ResponseBeginBlock{Events: events},
+		}))
+	}
+
+	deleted, err := pruneTxIndex(context.Background(), store, 6)
+	require.NoError(t, err)
+	require.Positive(t, deleted)
+
+	for i, tx := range txs {
+		h := int64(i + 1)
+		res, err := txIndexer.Get(tx.Hash())
+		require.NoError(t, err)
+		has, err := blockIndexer.Has(h)
+		require.NoError(t, err)
+		require.Equal(t, h >= 6, res != nil, "tx at height %d", h)
+		require.Equal(t, h >= 6, has, "block at height %d", h)
+	}
+
+	// every remaining key belongs to a retained height
+	itr, err := store.Iterator(nil, nil)
+	require.NoError(t, err)
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		if height, ok := txIndexKeyHeight(itr.Key()); ok {
+			require.GreaterOrEqual(t, height, int64(6), "%q", itr.Key())
+		}
+	}
+}
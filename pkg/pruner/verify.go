@@ -0,0 +1,179 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/state"
+	tmstore "github.com/cometbft/cometbft/store"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// VerifyResult is what Verify checked: the retained blocks and states, and
+// the retained versions of the application state. Fields of the parts that
+// were skipped or are missing are zero.
+type VerifyResult struct {
+	BlockBase   int64
+	BlockHeight int64
+	StateHeight int64
+	// EarliestVersion and LatestVersion are the oldest and newest versions of
+	// the application state, whose stores all loaded.
+	EarliestVersion int64
+	LatestVersion   int64
+	Stores          int
+}
+
+// Verify checks, read only, that a pruned data directory is usable: the block
+// store has the blocks at its base and height, the state store has the state
+// and the validators from the block base on, and every store of the
+// application state loads at its oldest and latest version, with the hashes
+// of the latest commit info. Options.SkipBlocks and Options.SkipApp leave
+// their part out.
+func (p *Pruner) Verify(ctx context.Context) (*VerifyResult, error) {
+	result := &VerifyResult{}
+	if !p.opts.SkipBlocks {
+		if err := p.verifyBlocks(result); err != nil {
+			return result, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if !p.opts.SkipApp && !hasMemIAVL(p.opts.DataDir) {
+		if err := p.verifyApp(result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// verifyBlocks checks the block store and the state store.
+func (p *Pruner) verifyBlocks(result *VerifyResult) error {
+	dbDir := p.opts.DataDir
+	if _, err := os.Stat(filepath.Join(dbDir, "blockstore.db")); os.IsNotExist(err) {
+		p.logger.Info("no block store to verify")
+		return nil
+	}
+
+	blockStoreDB, err := p.openDB("blockstore", dbDir, true)
+	if err != nil {
+		return err
+	}
+	defer blockStoreDB.Close()
+	blockStore := tmstore.NewBlockStore(blockStoreDB)
+	result.BlockBase, result.BlockHeight = blockStore.Base(), blockStore.Height()
+	for _, h := range []int64{result.BlockBase, result.BlockHeight} {
+		block := blockStore.LoadBlock(h)
+		if block == nil {
+			return fmt.Errorf("block store is missing the block at height %d", h)
+		}
+		if blockStore.LoadBlockByHash(block.Hash()) == nil {
+			return fmt.Errorf("block store is missing the hash index of the block at height %d", h)
+		}
+	}
+	p.logger.Info("verified block store", "base", result.BlockBase, "height", result.BlockHeight)
+
+	if _, err := os.Stat(filepath.Join(dbDir, "state.db")); os.IsNotExist(err) {
+		return nil
+	}
+	stateDB, err := p.openDB("state", dbDir, true)
+	if err != nil {
+		return err
+	}
+	defer stateDB.Close()
+	stateStore := state.NewStore(stateDB, state.StoreOptions{})
+	latest, err := stateStore.Load()
+	if err != nil {
+		return err
+	}
+	if latest.IsEmpty() {
+		return fmt.Errorf("state store has no state")
+	}
+	result.StateHeight = latest.LastBlockHeight
+	if result.StateHeight < result.BlockBase {
+		return fmt.Errorf("state is at height %d, below the block store base %d", result.StateHeight, result.BlockBase)
+	}
+	if _, err := stateStore.LoadValidators(result.BlockBase); err != nil {
+		return fmt.Errorf("validators at the block store base %d: %w", result.BlockBase, err)
+	}
+	if _, err := stateStore.LoadConsensusParams(result.BlockBase); err != nil {
+		return fmt.Errorf("consensus params at the block store base %d: %w", result.BlockBase, err)
+	}
+	p.logger.Info("verified state store", "height", result.StateHeight)
+	return nil
+}
+
+// verifyApp loads the stores of the application state at its oldest and
+// latest version and checks the latest against the commit info.
+func (p *Pruner) verifyApp(result *VerifyResult) error {
+	dbDir := p.opts.DataDir
+	if _, err := os.Stat(filepath.Join(dbDir, "application.db")); os.IsNotExist(err) {
+		p.logger.Info("no application state to verify")
+		return nil
+	}
+
+	appDB, err := p.openDB("application", dbDir, true)
+	if err != nil {
+		return err
+	}
+	defer appDB.Close()
+
+	names, err := rootmulti.LatestStoreNames(appDB)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*storetypes.KVStoreKey, len(names))
+	for _, name := range names {
+		keys[name] = storetypes.NewKVStoreKey(name)
+	}
+	separateDBs, err := p.openStoreDBs(dbDir, keys, true)
+	if err != nil {
+		return err
+	}
+	defer closeStoreDBs(separateDBs)
+	storeDBs := make(map[string]db.DB, len(separateDBs))
+	for name, database := range separateDBs {
+		storeDBs[name] = database
+	}
+
+	result.LatestVersion = rootmulti.GetLatestVersion(appDB)
+	cInfo, err := rootmulti.NewStore(appDB, p.logger).GetCommitInfo(result.LatestVersion)
+	if err != nil {
+		return err
+	}
+	appStore, err := rootmulti.LoadStoresAt(appDB, p.logger, storeDBs, result.LatestVersion, names...)
+	if err != nil {
+		return fmt.Errorf("latest version %d: %w", result.LatestVersion, err)
+	}
+	for _, info := range cInfo.StoreInfos {
+		store, ok := appStore.GetStoreByName(info.Name).(storetypes.CommitKVStore)
+		if !ok {
+			continue
+		}
+		if hash := store.LastCommitID().Hash; !bytes.Equal(hash, info.CommitId.Hash) {
+			return fmt.Errorf("store %s: hash %X does not match the commit info hash %X at version %d",
+				info.Name, hash, info.CommitId.Hash, result.LatestVersion)
+		}
+	}
+	result.Stores = len(names)
+
+	versions, err := rootmulti.CommitInfoVersions(appDB)
+	if err != nil {
+		return err
+	}
+	result.EarliestVersion = result.LatestVersion
+	if len(versions) > 0 && versions[0] < result.LatestVersion {
+		result.EarliestVersion = versions[0]
+		if _, err := rootmulti.LoadStoresAt(appDB, p.logger, storeDBs, result.EarliestVersion, names...); err != nil {
+			return fmt.Errorf("earliest version %d: %w", result.EarliestVersion, err)
+		}
+	}
+	p.logger.Info("verified application state", "stores", result.Stores, "earliest", result.EarliestVersion, "latest", result.LatestVersion)
+	return nil
+}
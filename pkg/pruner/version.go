@@ -0,0 +1,185 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	db "github.com/cometbft/cometbft-db"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// NodeVersion is the CometBFT release line that wrote a data directory. It
+// also gives away the Cosmos SDK one, as SDK v0.47 runs on CometBFT 0.37 and
+// SDK v0.50 on CometBFT 0.38.
+type NodeVersion int
+
+const (
+	NodeVersionUnknown NodeVersion = iota
+	NodeVersion037
+	NodeVersion038
+)
+
+func (v NodeVersion) String() string {
+	switch v {
+	case NodeVersion037:
+		return "cometbft 0.37 / cosmos-sdk 0.47"
+	case NodeVersion038:
+		return "cometbft 0.38 / cosmos-sdk 0.50"
+	}
+	return "unknown"
+}
+
+// extendedCommitPrefix is the prefix of the extended commits CometBFT 0.38
+// saves in the block store, with their vote extensions, as EC:<height>.
+var extendedCommitPrefix = []byte("EC:")
+
+// the protobuf fields that only CometBFT 0.38 writes
+const (
+	// ConsensusParams.abci, in State.consensus_params
+	stateConsensusParamsField = 10
+	consensusParamsABCIField  = 5
+	// ABCIResponsesInfo.response_finalize_block, under lastABCIResponseKey
+	abciResponsesFinalizeBlockField = 3
+)
+
+// detectNodeVersion tells the CometBFT release line that wrote the block
+// store and state in dbDir from what it stores: CometBFT 0.38 keeps the
+// FinalizeBlock response of the last height, ABCI consensus params and, with
+// vote extensions, extended commits, none of which 0.37 knows of. Without a
+// state store the version is unknown.
+func (p *Pruner) detectNodeVersion(dbDir string) (NodeVersion, error) {
+	if _, err := os.Stat(filepath.Join(dbDir, "state.db")); os.IsNotExist(err) {
+		return NodeVersionUnknown, nil
+	}
+	stateDB, err := p.openDB("state", dbDir, true)
+	if err != nil {
+		return NodeVersionUnknown, err
+	}
+	defer stateDB.Close()
+
+	bz, err := stateDB.Get([]byte("lastABCIResponseKey"))
+	if err != nil {
+		return NodeVersionUnknown, err
+	}
+	if hasField(bz, abciResponsesFinalizeBlockField) {
+		return NodeVersion038, nil
+	}
+
+	bz, err = stateDB.Get([]byte("stateKey"))
+	if err != nil {
+		return NodeVersionUnknown, err
+	}
+	if bz == nil {
+		return NodeVersionUnknown, nil
+	}
+	for _, params := range fieldValues(bz, stateConsensusParamsField) {
+		if hasField(params, consensusParamsABCIField) {
+			return NodeVersion038, nil
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dbDir, "blockstore.db")); err == nil {
+		blockStoreDB, err := p.openDB("blockstore", dbDir, true)
+		if err != nil {
+			return NodeVersionUnknown, err
+		}
+		defer blockStoreDB.Close()
+		itr, err := db.IteratePrefix(blockStoreDB, extendedCommitPrefix)
+		if err != nil {
+			return NodeVersionUnknown, err
+		}
+		defer itr.Close()
+		if itr.Valid() {
+			return NodeVersion038, nil
+		}
+	}
+	return NodeVersion037, nil
+}
+
+// hasField reports whether the protobuf message bz has the given field.
+func hasField(bz []byte, field protowire.Number) bool {
+	found := false
+	walkFields(bz, func(num protowire.Number, _ []byte) {
+		found = found || num == field
+	})
+	return found
+}
+
+// fieldValues returns every occurrence of a length delimited field of the
+// protobuf message bz.
+func fieldValues(bz []byte, field protowire.Number) [][]byte {
+	var values [][]byte
+	walkFields(bz, func(num protowire.Number, value []byte) {
+		if num == field && value != nil {
+			values = append(values, value)
+		}
+	})
+	return values
+}
+
+// walkFields calls fn with the number of every top level field of the
+// protobuf message bz, and the value of length delimited ones, stopping at
+// the first malformed field.
+func walkFields(bz []byte, fn func(protowire.Number, []byte)) {
+	for len(bz) > 0 {
+		num, typ, n := protowire.ConsumeTag(bz)
+		if n < 0 {
+			return
+		}
+		bz = bz[n:]
+		var value []byte
+		if typ == protowire.BytesType {
+			value, n = protowire.ConsumeBytes(bz)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, bz)
+		}
+		if n < 0 {
+			return
+		}
+		fn(num, value)
+		bz = bz[n:]
+	}
+}
+
+// pruneExtendedCommits deletes the extended commits below pruneHeight, which
+// the CometBFT 0.37 block store does not know to prune, and returns the
+// number deleted.
+func pruneExtendedCommits(ctx context.Context, blockStoreDB db.DB, pruneHeight int64) (int, error) {
+	itr, err := db.IteratePrefix(blockStoreDB, extendedCommitPrefix)
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	batch := blockStoreDB.NewBatch()
+	defer func() { batch.Close() }()
+	var deleted, pending int
+	for ; itr.Valid(); itr.Next() {
+		key := itr.Key()
+		height, err := strconv.ParseInt(string(bytes.TrimPrefix(key, extendedCommitPrefix)), 10, 64)
+		if err != nil || height >= pruneHeight {
+			continue
+		}
+		if err := batch.Delete(key); err != nil {
+			return deleted, err
+		}
+		deleted++
+		if pending++; pending == copyBatchKeys {
+			if err := batch.Write(); err != nil {
+				return deleted, err
+			}
+			batch.Close()
+			batch, pending = blockStoreDB.NewBatch(), 0
+			if err := ctx.Err(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return deleted, err
+	}
+	return deleted, batch.Write()
+}
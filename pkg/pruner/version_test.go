@@ -0,0 +1,172 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	cmtstate "github.com/cometbft/cometbft/proto/tendermint/state"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestDetectNodeVersion(t *testing.T) {
+	p := newTestPruner(t, Options{DataDir: t.TempDir()})
+	version, err := p.detectNodeVersion(t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, NodeVersionUnknown, version)
+
+	dir := t.TempDir()
+	saveTestStates(t, dir, 10, 5)
+	saveTestBlocks(t, dir, 10)
+	version, err = p.detectNodeVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, NodeVersion037, version)
+
+	// an extended commit in the block store
+	blockStoreDB, err := db.NewGoLevelDB("blockstore", dir)
+	require.NoError(t, err)
+	require.NoError(t, blockStoreDB.Set([]byte("EC:10"), []byte{1}))
+	require.NoError(t, blockStoreDB.Close())
+	version, err = p.detectNodeVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, NodeVersion038, version)
+
+	// ABCI consensus params in the state
+	dir = t.TempDir()
+	saveTestStates(t, dir, 10, 5)
+	stateDB, err := db.NewGoLevelDB("state", dir)
+	require.NoError(t, err)
+	bz, err := stateDB.Get([]byte("stateKey"))
+	require.NoError(t, err)
+	params := protowire.AppendTag(nil, consensusParamsABCIField, protowire.BytesType)
+	params = protowire.AppendBytes(params, []byte{})
+	bz = protowire.AppendTag(bz, stateConsensusParamsField, protowire.BytesType)
+	bz = protowire.AppendBytes(bz, params)
+	require.NoError(t, stateDB.Set([]byte("stateKey"), bz))
+	require.NoError(t, stateDB.Close())
+	version, err = p.detectNodeVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, NodeVersion038, version)
+
+	// the FinalizeBlock response of the last height
+	dir = t.TempDir()
+	saveTestStates(t, dir, 10, 5)
+	stateDB, err = db.NewGoLevelDB("state", dir)
+	require.NoError(t, err)
+	bz = protowire.AppendTag(nil, 2, protowire.VarintType)
+	bz = protowire.AppendVarint(bz, 10)
+	bz = protowire.AppendTag(bz, abciResponsesFinalizeBlockField, protowire.BytesType)
+	bz = protowire.AppendBytes(bz, []byte{})
+	require.NoError(t, stateDB.Set([]byte("lastABCIResponseKey"), bz))
+	require.NoError(t, stateDB.Close())
+	version, err = p.detectNodeVersion(dir)
+	require.NoError(t, err)
+	require.Equal(t, NodeVersion038, version)
+}
+
+func TestPruneExtendedCommits(t *testing.T) {
+	blockStoreDB := db.NewMemDB()
+	for h := 1; h <= 20; h++ {
+		require.NoError(t, blockStoreDB.Set([]byte(fmt.Sprintf("EC:%d", h)), []byte{1}))
+		require.NoError(t, blockStoreDB.Set([]byte(fmt.Sprintf("C:%d", h)), []byte{1}))
+	}
+
+	pruned, err := pruneExtendedCommits(context.Background(), blockStoreDB, 15)
+	require.NoError(t, err)
+	require.Equal(t, 14, pruned)
+	for h := 1; h <= 20; h++ {
+		has, err := blockStoreDB.Has([]byte(fmt.Sprintf("EC:%d", h)))
+		require.NoError(t, err)
+		require.Equal(t, h >= 15, has, "height %d", h)
+		has, err = blockStoreDB.Has([]byte(fmt.Sprintf("C:%d", h)))
+		require.NoError(t, err)
+		require.True(t, has, "height %d", h)
+	}
+}
+
+// withABCIParams returns the ConsensusParamsInfo bz with ABCI params added to
+// its consensus params, as CometBFT 0.38 saves them.
+func withABCIParams(t *testing.T, bz, abciParams []byte) []byte {
+	var info cmtstate.ConsensusParamsInfo
+	require.NoError(t, info.Unmarshal(bz))
+	params, err := info.ConsensusParams.Marshal()
+	require.NoError(t, err)
+	params = protowire.AppendTag(params, consensusParamsABCIField, protowire.BytesType)
+	params = protowire.AppendBytes(params, abciParams)
+
+	out := protowire.AppendTag(nil, 1, protowire.BytesType)
+	out = protowire.AppendBytes(out, params)
+	out = protowire.AppendTag(out, 2, protowire.VarintType)
+	return protowire.AppendVarint(out, uint64(info.LastHeightChanged))
+}
+
+func TestPruneStateStore038(t *testing.T) {
+	dir := t.TempDir()
+	saveTestBlocks(t, dir, 40)
+	saveTestStates(t, dir, 40, 13)
+
+	// vote extensions enabled from height 7, in the params of genesis that
+	// every height refers back to
+	abciParams := protowire.AppendTag(nil, 1, protowire.VarintType)
+	abciParams = protowire.AppendVarint(abciParams, 7)
+	stateDB, err := db.NewGoLevelDB("state", dir)
+	require.NoError(t, err)
+	info, err := stateDB.Get([]byte("consensusParamsKey:1"))
+	require.NoError(t, err)
+	info = withABCIParams(t, info, abciParams)
+	require.NoError(t, stateDB.Set([]byte("consensusParamsKey:1"), info))
+	bz, err := stateDB.Get([]byte("stateKey"))
+	require.NoError(t, err)
+	params := protowire.AppendTag(nil, consensusParamsABCIField, protowire.BytesType)
+	params = protowire.AppendBytes(params, abciParams)
+	bz = protowire.AppendTag(bz, stateConsensusParamsField, protowire.BytesType)
+	bz = protowire.AppendBytes(bz, params)
+	require.NoError(t, stateDB.Set([]byte("stateKey"), bz))
+	require.NoError(t, stateDB.Close())
+
+	before, err := os.Stat(filepath.Join(dir, "state.db"))
+	require.NoError(t, err)
+	p := newTestPruner(t, Options{DataDir: dir, KeepBlocks: 10, Strategy: StrategyDelete, SkipApp: true})
+	_, err = p.PruneBlocks(context.Background())
+	require.NoError(t, err)
+	// the state store is copied forward whatever the strategy, never saved
+	// again by the 0.37 state store
+	after, err := os.Stat(filepath.Join(dir, "state.db"))
+	require.NoError(t, err)
+	require.False(t, os.SameFile(before, after))
+
+	stateDB, err = db.NewGoLevelDB("state", dir)
+	require.NoError(t, err)
+	defer stateDB.Close()
+	has, err := stateDB.Has([]byte("consensusParamsKey:29"))
+	require.NoError(t, err)
+	require.False(t, has)
+	// the retained heights still refer to params with the ABCI params
+	for h := int64(30); h <= 41; h++ {
+		var info cmtstate.ConsensusParamsInfo
+		bz, err := stateDB.Get([]byte(fmt.Sprintf("consensusParamsKey:%d", h)))
+		require.NoError(t, err)
+		require.NoError(t, info.Unmarshal(bz))
+		require.EqualValues(t, 1, info.LastHeightChanged, "height %d", h)
+	}
+	bz, err = stateDB.Get([]byte("consensusParamsKey:1"))
+	require.NoError(t, err)
+	stored := fieldValues(bz, 1)
+	require.Len(t, stored, 1)
+	require.Equal(t, [][]byte{abciParams}, fieldValues(stored[0], consensusParamsABCIField))
+}
+
+func TestPruneStateStoreUnknownVersion(t *testing.T) {
+	dir := t.TempDir()
+	saveTestBlocks(t, dir, 40)
+	stateDB, err := db.NewGoLevelDB("state", dir)
+	require.NoError(t, err)
+	require.NoError(t, stateDB.Close())
+
+	_, err = newTestPruner(t, Options{DataDir: dir, KeepBlocks: 10, SkipApp: true}).PruneBlocks(context.Background())
+	require.ErrorContains(t, err, "CometBFT version")
+}
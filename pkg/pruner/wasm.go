@@ -0,0 +1,223 @@
+package pruner
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	db "github.com/cometbft/cometbft-db"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/diskusage"
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+// wasmCodePrefix is the prefix of the wasm store under which the CodeInfo of
+// every code ID is kept; CodeInfo field 1 is the checksum of the code.
+var wasmCodePrefix = []byte{0x01}
+
+const wasmCodeHashField = 1
+
+// wasmModuleVersion matches the directories the compiled module cache of
+// wasmvm keeps per module format, e.g. v9-wasmer4.
+var wasmModuleVersion = regexp.MustCompile(`^v(\d+)-wasmer(\d+)$`)
+
+// wasmReport is what the wasm phase found in the wasmvm directory.
+type wasmReport struct {
+	Size int64
+	// Codes is the number of code IDs in the wasm store
+	Codes int
+	// Blobs is the number of code blobs on disk
+	Blobs int
+	// MissingBlobs are checksums of the wasm store without a blob on disk
+	MissingBlobs []string
+	// OrphanBlobs are blobs on disk no code ID uses
+	OrphanBlobs []string
+	// StaleCaches are the compiled module caches of older wasmvm versions
+	StaleCaches []string
+	Reclaimed   int64
+}
+
+// findWasmDir returns the directory wasmvm keeps its state and cache in:
+// Options.WasmDir, or wasm/wasm in the node home or the data directory.
+func (p *Pruner) findWasmDir(dbDir string) (string, bool) {
+	candidates := []string{p.opts.WasmDir}
+	if p.opts.WasmDir == "" {
+		candidates = []string{filepath.Join(filepath.Dir(dbDir), "wasm"), filepath.Join(dbDir, "wasm")}
+	}
+	for _, dir := range candidates {
+		for _, base := range []string{filepath.Join(dir, "wasm"), dir} {
+			for _, sub := range []string{"state", "cache"} {
+				if info, err := os.Stat(filepath.Join(base, sub)); err == nil && info.IsDir() {
+					return base, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// pruneWasmDir removes the compiled module caches wasmvm left behind for
+// older module formats, cross references the code blobs on disk with the
+// code IDs of the wasm store and reports the space the directory uses.
+func (p *Pruner) pruneWasmDir(ctx context.Context) error {
+	dbDir := p.opts.DataDir
+	base, ok := p.findWasmDir(dbDir)
+	if !ok {
+		p.logger.Info("no wasm directory found", "data_dir", dbDir)
+		return nil
+	}
+
+	appDB, err := p.openDB("application", dbDir, true)
+	if err != nil {
+		return err
+	}
+	defer appDB.Close()
+	separateDBs, err := p.openStoreDBs(dbDir, map[string]*storetypes.KVStoreKey{"wasm": nil}, true)
+	if err != nil {
+		return err
+	}
+	defer closeStoreDBs(separateDBs)
+	storeDBs := make(map[string]db.DB, len(separateDBs))
+	for name, database := range separateDBs {
+		storeDBs[name] = database
+	}
+
+	p.logger.Info("cleaning up wasm directory", "dir", base)
+	report, err := p.cleanWasmDir(ctx, appDB, storeDBs, base)
+	if err != nil {
+		return err
+	}
+	for _, checksum := range report.MissingBlobs {
+		p.logger.Error("wasm code has no blob on disk", "checksum", checksum)
+	}
+	for _, checksum := range report.OrphanBlobs {
+		p.logger.Info("wasm blob is not used by any code", "checksum", checksum)
+	}
+	p.logger.Info("cleaning up wasm directory complete", "dir", base, "size", report.Size, "codes", report.Codes, "blobs", report.Blobs,
+		"missing_blobs", len(report.MissingBlobs), "orphan_blobs", len(report.OrphanBlobs),
+		"stale_caches", strings.Join(report.StaleCaches, ","), "reclaimed", report.Reclaimed)
+	return nil
+}
+
+// cleanWasmDir does the work of pruneWasmDir on the wasmvm directory base.
+// Blobs are only reported, never removed, as the node cannot rebuild them.
+func (p *Pruner) cleanWasmDir(ctx context.Context, appDB db.DB, storeDBs map[string]db.DB, base string) (wasmReport, error) {
+	var report wasmReport
+
+	checksums, err := p.wasmCodeChecksums(appDB, storeDBs)
+	if err != nil {
+		return report, err
+	}
+	report.Codes = len(checksums)
+
+	blobs := make(map[string]bool)
+	entries, err := os.ReadDir(filepath.Join(base, "state", "wasm"))
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			// wasmvm 1.x names blobs by checksum, later versions add .wasm
+			blobs[strings.TrimSuffix(e.Name(), ".wasm")] = true
+		}
+	}
+	report.Blobs = len(blobs)
+	for checksum := range checksums {
+		if !blobs[checksum] {
+			report.MissingBlobs = append(report.MissingBlobs, checksum)
+		}
+	}
+	for checksum := range blobs {
+		if !checksums[checksum] {
+			report.OrphanBlobs = append(report.OrphanBlobs, checksum)
+		}
+	}
+	slices.Sort(report.MissingBlobs)
+	slices.Sort(report.OrphanBlobs)
+
+	// wasmvm only reads the cache of its own module format, which is the
+	// newest one after an upgrade
+	modulesDir := filepath.Join(base, "cache", "modules")
+	entries, err = os.ReadDir(modulesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+	var versions []string
+	for _, e := range entries {
+		if e.IsDir() && wasmModuleVersion.MatchString(e.Name()) {
+			versions = append(versions, e.Name())
+		}
+	}
+	slices.SortFunc(versions, compareWasmModuleVersions)
+	for _, version := range versions[:max(len(versions)-1, 0)] {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		dir := filepath.Join(modulesDir, version)
+		size, err := diskusage.DirSize(dir)
+		if err != nil {
+			return report, err
+		}
+		p.logger.Info("removing stale wasm module cache", "version", version, "size", size)
+		if err := os.RemoveAll(dir); err != nil {
+			return report, err
+		}
+		report.StaleCaches = append(report.StaleCaches, version)
+		report.Reclaimed += size
+	}
+
+	if report.Size, err = diskusage.DirSize(base); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// compareWasmModuleVersions orders module cache directories by module format,
+// then wasmer version.
+func compareWasmModuleVersions(a, b string) int {
+	ma, mb := wasmModuleVersion.FindStringSubmatch(a), wasmModuleVersion.FindStringSubmatch(b)
+	for i := 1; i <= 2; i++ {
+		x, _ := strconv.Atoi(ma[i])
+		y, _ := strconv.Atoi(mb[i])
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// wasmCodeChecksums returns the hex checksums of the codes in the latest
+// version of the wasm store, which storeDBs may keep out of appDB.
+func (p *Pruner) wasmCodeChecksums(appDB db.DB, storeDBs map[string]db.DB) (map[string]bool, error) {
+	names, err := rootmulti.LatestStoreNames(appDB)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(names, "wasm") {
+		return nil, fmt.Errorf("the application has no wasm store")
+	}
+	appStore, err := rootmulti.LoadStoresAt(appDB, p.logger, storeDBs, 0, "wasm")
+	if err != nil {
+		return nil, err
+	}
+
+	store := appStore.GetKVStore(appStore.StoreKeysByName()["wasm"])
+	itr := storetypes.KVStorePrefixIterator(store, wasmCodePrefix)
+	defer itr.Close()
+	checksums := make(map[string]bool)
+	for ; itr.Valid(); itr.Next() {
+		values := fieldValues(itr.Value(), wasmCodeHashField)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("code %X has no checksum", itr.Key())
+		}
+		checksums[hex.EncodeToString(values[0])] = true
+	}
+	return checksums, itr.Error()
+}
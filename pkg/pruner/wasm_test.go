@@ -0,0 +1,72 @@
+package pruner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	db "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/libs/log"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/binaryholdings/cosmos-pruner/internal/rootmulti"
+)
+
+func TestCleanWasmDir(t *testing.T) {
+	checksum := func(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+
+	appDB := db.NewMemDB()
+	appStore := rootmulti.NewStore(appDB, log.NewNopLogger())
+	key := storetypes.NewKVStoreKey("wasm")
+	appStore.MountStoreWithDB(key, storetypes.StoreTypeIAVL, nil)
+	require.NoError(t, appStore.LoadLatestVersion())
+	appStore.SetCommitHeader(cmtproto.Header{Height: 1})
+	for id, b := range []byte{0xaa, 0xbb} {
+		codeInfo := protowire.AppendTag(nil, wasmCodeHashField, protowire.BytesType)
+		codeInfo = protowire.AppendBytes(codeInfo, checksum(b))
+		codeInfo = protowire.AppendTag(codeInfo, 2, protowire.BytesType)
+		codeInfo = protowire.AppendBytes(codeInfo, []byte("creator"))
+		appStore.GetKVStore(key).Set(binary.BigEndian.AppendUint64(wasmCodePrefix, uint64(id+1)), codeInfo)
+	}
+	appStore.Commit()
+
+	// blob bb is missing and blob cc is not used by any code
+	base := filepath.Join(t.TempDir(), "wasm", "wasm")
+	write := func(path string, size int) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(base, path)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(base, path), make([]byte, size), 0o644))
+	}
+	write("state/wasm/"+hex.EncodeToString(checksum(0xaa)), 100)
+	write("state/wasm/"+hex.EncodeToString(checksum(0xcc))+".wasm", 100)
+	write("cache/modules/v4-wasmer1/"+hex.EncodeToString(checksum(0xaa))+".module", 1000)
+	write("cache/modules/v5-wasmer2/"+hex.EncodeToString(checksum(0xaa))+".module", 1000)
+	write("cache/modules/v10-wasmer2/"+hex.EncodeToString(checksum(0xaa))+".module", 500)
+
+	dataDir := filepath.Join(filepath.Dir(filepath.Dir(base)), "data")
+	p := newTestPruner(t, Options{DataDir: dataDir})
+	found, ok := p.findWasmDir(dataDir)
+	require.True(t, ok)
+	require.Equal(t, base, found)
+
+	report, err := p.cleanWasmDir(context.Background(), appDB, nil, base)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Codes)
+	require.Equal(t, 2, report.Blobs)
+	require.Equal(t, []string{hex.EncodeToString(checksum(0xbb))}, report.MissingBlobs)
+	require.Equal(t, []string{hex.EncodeToString(checksum(0xcc))}, report.OrphanBlobs)
+	require.Equal(t, []string{"v4-wasmer1", "v5-wasmer2"}, report.StaleCaches)
+	require.EqualValues(t, 2000, report.Reclaimed)
+	require.EqualValues(t, 700, report.Size)
+
+	entries, err := os.ReadDir(filepath.Join(base, "cache", "modules"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "v10-wasmer2", entries[0].Name())
+}